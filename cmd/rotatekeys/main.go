@@ -0,0 +1,65 @@
+// The rotatekeys command re-wraps the data key of every snippet with the current master key.
+//
+// To rotate the master key, restart the web application with the new key as -master-key-file and the
+// old one as -previous-master-key-file, then run this command with the same two keys, most simply with
+// the same configuration file or environment. Once it has finished, the previous key is no longer needed.
+// Snippets still stored in plain text are encrypted too.
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"os"
+
+	"snippetbox.t10i.net/internal/envelope"
+	"snippetbox.t10i.net/internal/models"
+	"snippetbox.t10i.net/internal/settings"
+)
+
+func main() {
+	// The settings are loaded like those of the web application, so the command can be pointed at the same
+	// configuration file with -config or SNIPPETBOX_CONFIG, and reads the same SNIPPETBOX_* environment
+	// variables. The settings of the web application which it doesn't need are skipped.
+	fs := flag.NewFlagSet("rotatekeys", flag.ExitOnError)
+	fs.String("config", "", "Configuration file (.toml, .yaml or .json)")
+	dsn := fs.String("dsn", "web:normaluser@/snippetbox?parseTime=true", "MySQL data source name")
+	fs.String("dsn-file", "", "File containing the MySQL data source name, instead of -dsn")
+	masterKeyFile := fs.String("master-key-file", "", "File containing the base64-encoded new master key")
+	previousMasterKeyFile := fs.String("previous-master-key-file", "", "File containing the previous master key")
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	sources, err := settings.Load(fs, os.Args[1:], true)
+	if err == nil {
+		err = settings.LoadFromFile(fs, sources, "dsn")
+	}
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	keys, err := envelope.LoadKeyring(*masterKeyFile, *previousMasterKeyFile)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	db, err := models.OpenDB(*dsn)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	snippets := &models.SnippetModel{DB: db, Keys: keys}
+
+	logger.Info("re-wrapping data keys", "key_id", keys.PrimaryID())
+
+	updated, err := snippets.RewrapKeys()
+	if err != nil {
+		logger.Error(err.Error(), "updated", updated)
+		os.Exit(1)
+	}
+
+	logger.Info("finished re-wrapping data keys", "updated", updated)
+}
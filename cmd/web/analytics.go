@@ -0,0 +1,211 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"snippetbox.t10i.net/internal/models"
+)
+
+// A viewEvent is a single counted access to a snippet.
+type viewEvent struct {
+	snippetID int
+	kind      string
+	visitor   string
+	referrer  string
+	day       time.Time
+}
+
+// viewCounter aggregates view events in memory and writes them to the database in batches,
+// from a goroutine of its own, so that counting a view never makes a request wait for the database.
+// If the events arrive faster than they can be written, the excess is dropped rather than queued forever.
+type viewCounter struct {
+	analytics *models.AnalyticsModel
+	logger    *slog.Logger
+	events    chan viewEvent
+	done      chan struct{}
+	wg        sync.WaitGroup
+	dropped   atomic.Int64
+}
+
+// How often the aggregated counts are written, and how many events can wait to be aggregated.
+const (
+	viewFlushInterval = 10 * time.Second
+	viewQueueSize     = 4096
+)
+
+func newViewCounter(analytics *models.AnalyticsModel, logger *slog.Logger) *viewCounter {
+	c := &viewCounter{
+		analytics: analytics,
+		logger:    logger,
+		events:    make(chan viewEvent, viewQueueSize),
+		done:      make(chan struct{}),
+	}
+
+	c.wg.Add(1)
+	go c.run()
+
+	return c
+}
+
+// Count queues a view event without blocking.
+func (c *viewCounter) Count(ev viewEvent) {
+	select {
+	case c.events <- ev:
+	default:
+		c.dropped.Add(1)
+	}
+}
+
+// Close stops the counter after writing everything that is still queued.
+func (c *viewCounter) Close() {
+	close(c.done)
+	c.wg.Wait()
+}
+
+type viewKey struct {
+	snippetID int
+	day       time.Time
+	kind      string
+}
+
+type referrerKey struct {
+	snippetID int
+	day       time.Time
+	host      string
+}
+
+// viewBatch holds the counts aggregated since the last flush.
+type viewBatch struct {
+	views     map[viewKey]int
+	visitors  map[models.VisitorHit]bool
+	referrers map[referrerKey]int
+}
+
+func newViewBatch() *viewBatch {
+	return &viewBatch{
+		views:     make(map[viewKey]int),
+		visitors:  make(map[models.VisitorHit]bool),
+		referrers: make(map[referrerKey]int),
+	}
+}
+
+func (b *viewBatch) add(ev viewEvent) {
+	b.views[viewKey{ev.snippetID, ev.day, ev.kind}]++
+	b.visitors[models.VisitorHit{SnippetID: ev.snippetID, Day: ev.day, Visitor: ev.visitor}] = true
+	if ev.referrer != "" {
+		b.referrers[referrerKey{ev.snippetID, ev.day, ev.referrer}]++
+	}
+}
+
+func (c *viewCounter) run() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(viewFlushInterval)
+	defer ticker.Stop()
+
+	batch := newViewBatch()
+
+	for {
+		select {
+		case ev := <-c.events:
+			batch.add(ev)
+		case <-ticker.C:
+			c.flush(batch)
+			batch = newViewBatch()
+		case <-c.done:
+			// Aggregate whatever is still queued before the final flush.
+			for {
+				select {
+				case ev := <-c.events:
+					batch.add(ev)
+				default:
+					c.flush(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+func (c *viewCounter) flush(batch *viewBatch) {
+	if dropped := c.dropped.Swap(0); dropped > 0 {
+		c.logger.Warn("dropped view events", "count", dropped)
+	}
+
+	if len(batch.views) == 0 {
+		return
+	}
+
+	views := make([]models.ViewCount, 0, len(batch.views))
+	for k, n := range batch.views {
+		views = append(views, models.ViewCount{SnippetID: k.snippetID, Day: k.day, Kind: k.kind, Views: n})
+	}
+
+	visitors := make([]models.VisitorHit, 0, len(batch.visitors))
+	for v := range batch.visitors {
+		visitors = append(visitors, v)
+	}
+
+	referrers := make([]models.ReferrerCount, 0, len(batch.referrers))
+	for k, n := range batch.referrers {
+		referrers = append(referrers, models.ReferrerCount{SnippetID: k.snippetID, Day: k.day, Host: k.host, Views: n})
+	}
+
+	err := c.analytics.Save(views, visitors, referrers)
+	if err != nil {
+		c.logger.Error("saving view counts", "error", err.Error())
+	}
+}
+
+// countView records an access of the given kind to a snippet.
+func (app *application) countView(r *http.Request, snippet models.Snippet, kind string) {
+	day := time.Now().UTC().Truncate(24 * time.Hour)
+
+	app.metrics.snippetsViewed.WithLabelValues(kind).Inc()
+
+	app.views.Count(viewEvent{
+		snippetID: snippet.ID,
+		kind:      kind,
+		visitor:   app.visitorHash(r, day),
+		referrer:  referrerHost(r),
+		day:       day,
+	})
+}
+
+// analyticsKeyEnv is the environment variable that the analytics key is read from when no key file is given.
+const analyticsKeyEnv = "SNIPPETBOX_ANALYTICS_KEY"
+
+// visitorHash identifies a visitor for counting unique visitors, without storing their IP address.
+// The hash is keyed with the analytics key and the day, so the same IP can't be followed across days.
+func (app *application) visitorHash(r *http.Request, day time.Time) string {
+	mac := hmac.New(sha256.New, app.visitorKey)
+	mac.Write([]byte(day.Format(time.DateOnly)))
+	mac.Write([]byte(clientIP(r)))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// referrerHost returns the host of the page that linked to the request,
+// or an empty string if there was none or it was a page of Snippetbox itself.
+func referrerHost(r *http.Request) string {
+	u, err := url.Parse(r.Referer())
+	if err != nil || u.Host == "" || strings.EqualFold(u.Host, r.Host) {
+		return ""
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if len(host) > 255 {
+		host = host[:255]
+	}
+
+	return host
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+
+	"snippetbox.t10i.net/internal/models"
+	"snippetbox.t10i.net/internal/validator"
+)
+
+// The largest JSON request body the API accepts.
+const maxJSONBodySize = 1 << 20
+
+// jsonObject is the top level of every JSON response, like {"collection": {...}} or {"error": "..."}.
+type jsonObject map[string]any
+
+// writeJSON sends data as a JSON response with the given status code.
+func (app *application) writeJSON(w http.ResponseWriter, r *http.Request, status int, data jsonObject) {
+	js, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(js, '\n'))
+}
+
+// errorJSON sends a JSON error response. Unlike clientError() it keeps API clients in JSON.
+func (app *application) errorJSON(w http.ResponseWriter, r *http.Request, status int, message any) {
+	app.writeJSON(w, r, status, jsonObject{"error": message})
+}
+
+// readJSON decodes a JSON request body into dst. The body must be a single JSON value, with no unknown fields,
+// sent with a Content-Type of application/json. Requiring that content type also stops other sites from
+// making requests to the API with plain HTML forms, which can't send it.
+func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any) error {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		return errors.New("the body must be sent with Content-Type: application/json")
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxJSONBodySize)
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dst)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			return fmt.Errorf("the body must not be larger than %d bytes", maxJSONBodySize)
+		}
+		return fmt.Errorf("the body is not valid JSON: %w", err)
+	}
+
+	if dec.Decode(&struct{}{}) != io.EOF {
+		return errors.New("the body must only contain a single JSON value")
+	}
+
+	return nil
+}
+
+// collectionJSON is the JSON representation of a collection. Snippets is only filled in for a single collection.
+type collectionJSON struct {
+	Slug        string        `json:"slug"`
+	Title       string        `json:"title"`
+	Description string        `json:"description"`
+	Visibility  string        `json:"visibility"`
+	URL         string        `json:"url"`
+	CreatedAt   time.Time     `json:"created_at"`
+	UpdatedAt   time.Time     `json:"updated_at"`
+	Snippets    []snippetJSON `json:"snippets,omitempty"`
+}
+
+// snippetJSON is the JSON representation of a snippet in a collection. The content isn't included.
+type snippetJSON struct {
+	Slug      string    `json:"slug"`
+	Title     string    `json:"title"`
+	URL       string    `json:"url"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func newCollectionJSON(r *http.Request, c models.Collection, snippets []models.Snippet) collectionJSON {
+	cj := collectionJSON{
+		Slug:        c.Slug,
+		Title:       c.Title,
+		Description: c.Description,
+		Visibility:  string(c.Visibility),
+		URL:         baseURL(r) + c.Path(),
+		CreatedAt:   c.CreatedAt,
+		UpdatedAt:   c.UpdatedAt,
+	}
+
+	for _, s := range snippets {
+		cj.Snippets = append(cj.Snippets, snippetJSON{
+			Slug:      s.Slug,
+			Title:     s.Title,
+			URL:       baseURL(r) + s.Path(),
+			CreatedAt: s.CreatedAt,
+			ExpiresAt: s.ExpiresAt,
+		})
+	}
+
+	return cj
+}
+
+// collectionInput is the body of requests that create or change a collection.
+// When changing a collection, fields that are left out keep their current value.
+type collectionInput struct {
+	Title       *string `json:"title"`
+	Description *string `json:"description"`
+	Visibility  *string `json:"visibility"`
+}
+
+// apiCollections lists the collections of the current visitor.
+func (app *application) apiCollections(w http.ResponseWriter, r *http.Request) {
+	var collections []models.Collection
+
+	if ownerID := app.ownerID(r); ownerID != "" {
+		var err error
+
+		collections, err = app.collections.ByOwner(ownerID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	list := []collectionJSON{}
+	for _, c := range collections {
+		list = append(list, newCollectionJSON(r, c, nil))
+	}
+
+	app.writeJSON(w, r, http.StatusOK, jsonObject{"collections": list})
+}
+
+func (app *application) apiCollectionCreate(w http.ResponseWriter, r *http.Request) {
+	var input collectionInput
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorJSON(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	title, description, visibility := "", "", string(models.VisibilityPublic)
+	if input.Title != nil {
+		title = *input.Title
+	}
+	if input.Description != nil {
+		description = *input.Description
+	}
+	if input.Visibility != nil {
+		visibility = *input.Visibility
+	}
+
+	var v validator.Validator
+	checkCollection(&v, title, description, visibility)
+
+	if !v.Valid() {
+		app.errorJSON(w, r, http.StatusUnprocessableEntity, v.FieldErrors)
+		return
+	}
+
+	ownerID, err := app.ensureOwnerID(w, r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	collection, err := app.collections.Insert(title, description, models.Visibility(visibility), ownerID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Location", "/api/collections/"+collection.Slug)
+	app.writeJSON(w, r, http.StatusCreated, jsonObject{"collection": newCollectionJSON(r, collection, nil)})
+}
+
+// apiCollection sends a collection with the snippets in it that the current visitor may see.
+func (app *application) apiCollection(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.viewableCollection(w, r, r.PathValue("slug"))
+	if !ok {
+		return
+	}
+
+	app.sendCollectionJSON(w, r, http.StatusOK, collection)
+}
+
+func (app *application) sendCollectionJSON(w http.ResponseWriter, r *http.Request, status int, collection models.Collection) {
+	snippets, err := app.collectionSnippets(r, collection)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, r, status, jsonObject{"collection": newCollectionJSON(r, collection, snippets)})
+}
+
+func (app *application) apiCollectionUpdate(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.ownedCollection(w, r, r.PathValue("slug"))
+	if !ok {
+		return
+	}
+
+	var input collectionInput
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorJSON(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if input.Title != nil {
+		collection.Title = *input.Title
+	}
+	if input.Description != nil {
+		collection.Description = *input.Description
+	}
+	if input.Visibility != nil {
+		collection.Visibility = models.Visibility(*input.Visibility)
+	}
+
+	var v validator.Validator
+	checkCollection(&v, collection.Title, collection.Description, string(collection.Visibility))
+
+	if !v.Valid() {
+		app.errorJSON(w, r, http.StatusUnprocessableEntity, v.FieldErrors)
+		return
+	}
+
+	err = app.collections.Update(collection.ID, collection.Title, collection.Description, collection.Visibility)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	collection, err = app.collections.GetBySlug(collection.Slug)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sendCollectionJSON(w, r, http.StatusOK, collection)
+}
+
+func (app *application) apiCollectionDelete(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.ownedCollection(w, r, r.PathValue("slug"))
+	if !ok {
+		return
+	}
+
+	err := app.collections.Delete(collection.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiCollectionAdd adds a snippet to the end of a collection. The body names it by its slug: {"snippet": "..."}.
+func (app *application) apiCollectionAdd(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.ownedCollection(w, r, r.PathValue("slug"))
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Snippet string `json:"snippet"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorJSON(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	snippet, err := app.snippets.GetBySlug(r.Context(), input.Snippet)
+	if err == nil && !app.canView(r, snippet) {
+		err = models.ErrNoRecord
+	}
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.errorJSON(w, r, http.StatusUnprocessableEntity, jsonObject{"snippet": "This snippet does not exist"})
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	err = app.collections.AddSnippet(collection.ID, snippet.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrCollectionFull) {
+			app.errorJSON(w, r, http.StatusUnprocessableEntity,
+				jsonObject{"snippet": fmt.Sprintf("A collection cannot hold more than %d snippets", models.MaxCollectionSnippets)})
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sendCollectionJSON(w, r, http.StatusOK, collection)
+}
+
+func (app *application) apiCollectionRemove(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.ownedCollection(w, r, r.PathValue("slug"))
+	if !ok {
+		return
+	}
+
+	snippet, err := app.snippets.GetBySlug(r.Context(), r.PathValue("snippet"))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.errorJSON(w, r, http.StatusNotFound, "not found")
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	err = app.collections.RemoveSnippet(collection.ID, snippet.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sendCollectionJSON(w, r, http.StatusOK, collection)
+}
+
+// apiCollectionOrder puts the snippets of a collection in a new order, given as a list of slugs:
+// {"snippets": ["...", "..."]}. Snippets of the collection that aren't listed are moved to the end.
+func (app *application) apiCollectionOrder(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.ownedCollection(w, r, r.PathValue("slug"))
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Snippets []string `json:"snippets"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorJSON(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	snippets, err := app.collectionSnippets(r, collection)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	ids := make(map[string]int, len(snippets))
+	for _, s := range snippets {
+		ids[s.Slug] = s.ID
+	}
+
+	order := make([]int, 0, len(input.Snippets))
+	for _, slug := range input.Snippets {
+		id, ok := ids[slug]
+		if !ok {
+			app.errorJSON(w, r, http.StatusUnprocessableEntity, jsonObject{"snippets": "Snippet " + slug + " is not in this collection"})
+			return
+		}
+		order = append(order, id)
+	}
+
+	err = app.collections.Reorder(collection.ID, order)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidOrder) {
+			app.errorJSON(w, r, http.StatusUnprocessableEntity, jsonObject{"snippets": "Each snippet can only be listed once"})
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sendCollectionJSON(w, r, http.StatusOK, collection)
+}
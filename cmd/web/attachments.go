@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"slices"
+	"strings"
+
+	"snippetbox.t10i.net/internal/models"
+)
+
+// Limits for the files attached to a snippet. maxUploadSize caps the whole request body, leaving
+// room for the rest of the create form on top of the attachments themselves.
+const (
+	maxAttachments      = 5
+	maxAttachmentSize   = 5 << 20
+	maxUploadSize       = maxAttachments*maxAttachmentSize + 1<<20
+	maxMultipartMemory  = 1 << 20
+	attachmentsFormName = "attachments"
+)
+
+// The content types an attachment may have, as detected by http.DetectContentType().
+// Anything that a browser could render as active content, like HTML or SVG, is deliberately missing.
+var permittedAttachmentTypes = []string{
+	"text/plain",
+	"application/json",
+	"application/pdf",
+	"application/zip",
+	"application/x-gzip",
+	"application/octet-stream",
+	"image/png",
+	"image/jpeg",
+	"image/gif",
+	"image/webp",
+}
+
+// An upload is an attachment from the create form that passed validation and is waiting to be stored.
+type upload struct {
+	header      *multipart.FileHeader
+	contentType string
+}
+
+// checkAttachments validates the files attached to the create form, and returns them with their detected content type.
+func checkAttachments(form *snippetCreateForm, r *http.Request) []upload {
+	if r.MultipartForm == nil {
+		return nil
+	}
+
+	headers := r.MultipartForm.File[attachmentsFormName]
+	if len(headers) == 0 {
+		return nil
+	}
+
+	form.CheckField(!form.Encrypted, attachmentsFormName, "Encrypted snippets cannot have attachments")
+	form.CheckField(len(headers) <= maxAttachments, attachmentsFormName, fmt.Sprintf("A snippet cannot have more than %d attachments", maxAttachments))
+
+	var uploads []upload
+
+	for _, header := range headers {
+		if header.Size > maxAttachmentSize {
+			form.AddFieldError(attachmentsFormName, fmt.Sprintf("%s is larger than %d MB", header.Filename, maxAttachmentSize>>20))
+			continue
+		}
+
+		// Don't trust the Content-Type sent by the browser: sniff the content instead.
+		contentType, err := sniffContentType(header)
+		if err != nil {
+			form.AddFieldError(attachmentsFormName, fmt.Sprintf("%s could not be read", header.Filename))
+			continue
+		}
+
+		if !slices.Contains(permittedAttachmentTypes, contentType) {
+			form.AddFieldError(attachmentsFormName, fmt.Sprintf("%s has a file type that is not allowed", header.Filename))
+			continue
+		}
+
+		uploads = append(uploads, upload{header: header, contentType: contentType})
+	}
+
+	return uploads
+}
+
+// sniffContentType detects the content type of an uploaded file from its first 512 bytes,
+// dropping any parameters like the charset.
+func sniffContentType(header *multipart.FileHeader) (string, error) {
+	f, err := header.Open()
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	buf := make([]byte, 512)
+
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+
+	mediaType, _, err := mime.ParseMediaType(http.DetectContentType(buf[:n]))
+	if err != nil {
+		return "", err
+	}
+
+	return mediaType, nil
+}
+
+// storeAttachments puts the uploaded files in the blob store and records them against the snippet.
+func (app *application) storeAttachments(ctx context.Context, snippet models.Snippet, uploads []upload) error {
+	var keys []string
+
+	for _, u := range uploads {
+		key, err := app.storeAttachment(ctx, snippet, u)
+		if err != nil {
+			// Remove the blobs stored so far, as the caller deletes the snippet and with it their rows.
+			// The request may have been cancelled, so the cleanup gets a context of its own.
+			cleanupCtx := context.WithoutCancel(ctx)
+			for _, key := range keys {
+				app.blobs.Delete(cleanupCtx, key)
+			}
+			return err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return nil
+}
+
+// storeAttachment stores the blob of an upload and records it as an attachment of the snippet,
+// returning the key of the blob.
+func (app *application) storeAttachment(ctx context.Context, snippet models.Snippet, u upload) (string, error) {
+	b := make([]byte, 16)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	// The blob key never contains the user's file name, so it's always safe to use as a path.
+	key := snippet.Slug + "/" + hex.EncodeToString(b)
+
+	f, err := u.header.Open()
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	err = app.blobs.Put(ctx, key, f, u.header.Size, u.contentType)
+	if err != nil {
+		// A failed upload may still have left part of the blob behind.
+		app.blobs.Delete(context.WithoutCancel(ctx), key)
+		return "", err
+	}
+
+	_, err = app.attachments.Insert(snippet.ID, attachmentName(u.header.Filename), u.contentType, u.header.Size, key)
+	if err != nil {
+		// Don't leave a blob behind that no row refers to.
+		app.blobs.Delete(context.WithoutCancel(ctx), key)
+		return "", err
+	}
+
+	return key, nil
+}
+
+// attachmentName strips any directories from an uploaded file name, and falls back to a generic name if nothing is left.
+func attachmentName(filename string) string {
+	if i := strings.LastIndexAny(filename, `/\`); i >= 0 {
+		filename = filename[i+1:]
+	}
+
+	if filename == "" {
+		return "attachment"
+	}
+
+	return filename
+}
+
+// attachmentContentType returns the Content-Type an attachment is served with.
+// Text is always served as plain UTF-8 text, whatever it contains.
+func attachmentContentType(a models.Attachment) string {
+	if strings.HasPrefix(a.ContentType, "text/") {
+		return "text/plain; charset=utf-8"
+	}
+
+	return a.ContentType
+}
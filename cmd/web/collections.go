@@ -0,0 +1,345 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+
+	"snippetbox.t10i.net/internal/models"
+	"snippetbox.t10i.net/internal/validator"
+)
+
+// collectionForm holds the title, description and visibility of a collection,
+// on both the create form and the settings form on the collection's page.
+type collectionForm struct {
+	Title               string `form:"title"`
+	Description         string `form:"description"`
+	Visibility          string `form:"visibility"`
+	validator.Validator `form:"-"`
+}
+
+// collectionSnippetForm names a snippet to remove from or move within a collection,
+// or, on a snippet's page, the collection to add the snippet to.
+// Direction is only used for moving, and is either "up" or "down".
+type collectionSnippetForm struct {
+	Collection string `form:"collection"`
+	Snippet    string `form:"snippet"`
+	Direction  string `form:"direction"`
+}
+
+// checkCollection validates the fields of a collection. It's shared by the HTML forms and the JSON API.
+func checkCollection(v *validator.Validator, title, description, visibility string) {
+	v.CheckField(validator.NotBlank(title), "title", "This field cannot be blank")
+	v.CheckField(validator.MaxChars(title, 100), "title", "This field cannot be more than 100 characters long")
+	v.CheckField(validator.MaxChars(description, 2000), "description", "This field cannot be more than 2000 characters long")
+	v.CheckField(validator.PermittedValue(models.Visibility(visibility),
+		models.VisibilityPublic, models.VisibilityUnlisted, models.VisibilityPrivate),
+		"visibility", "This field must equal public, unlisted or private")
+}
+
+// ownsCollection reports whether the current visitor created the collection.
+func (app *application) ownsCollection(r *http.Request, collection models.Collection) bool {
+	ownerID := app.ownerID(r)
+	return ownerID != "" && ownerID == collection.OwnerID
+}
+
+// viewableCollection fetches the collection with the given slug, like viewableSnippet() does for snippets.
+// Private collections are only found for the visitor who created them.
+func (app *application) viewableCollection(w http.ResponseWriter, r *http.Request, slug string) (collection models.Collection, ok bool) {
+	collection, err := app.collections.GetBySlug(slug)
+	if err == nil && collection.Visibility == models.VisibilityPrivate && !app.ownsCollection(r, collection) {
+		err = models.ErrNoRecord
+	}
+
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+
+		return models.Collection{}, false
+	}
+
+	return collection, true
+}
+
+// ownedCollection fetches a collection that the current visitor is about to change.
+// Collections of other owners are treated as not found.
+func (app *application) ownedCollection(w http.ResponseWriter, r *http.Request, slug string) (collection models.Collection, ok bool) {
+	collection, ok = app.viewableCollection(w, r, slug)
+	if ok && !app.ownsCollection(r, collection) {
+		http.NotFound(w, r)
+		return models.Collection{}, false
+	}
+
+	return collection, ok
+}
+
+// collectionSnippets returns the snippets of a collection that the current visitor is allowed to see, in order.
+// A collection can contain private snippets of its owner, which nobody else gets to see.
+func (app *application) collectionSnippets(r *http.Request, collection models.Collection) ([]models.Snippet, error) {
+	snippets, err := app.snippets.InCollection(r.Context(), collection.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return slices.DeleteFunc(snippets, func(s models.Snippet) bool {
+		return !app.canView(r, s)
+	}), nil
+}
+
+func (app *application) collectionCreate(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = collectionForm{Visibility: string(models.VisibilityPublic)}
+
+	app.render(w, r, http.StatusOK, "collection_create.tmpl", data)
+}
+
+func (app *application) collectionCreatePost(w http.ResponseWriter, r *http.Request) {
+	var form collectionForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	checkCollection(&form.Validator, form.Title, form.Description, form.Visibility)
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "collection_create.tmpl", data)
+		return
+	}
+
+	ownerID, err := app.ensureOwnerID(w, r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	collection, err := app.collections.Insert(form.Title, form.Description, models.Visibility(form.Visibility), ownerID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, collection.Path(), http.StatusSeeOther)
+}
+
+// collectionView shows a collection with its snippets. Its owner also gets the forms to change it.
+func (app *application) collectionView(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.viewableCollection(w, r, r.PathValue("slug"))
+	if !ok {
+		return
+	}
+
+	app.renderCollection(w, r, http.StatusOK, collection, collectionForm{
+		Title:       collection.Title,
+		Description: collection.Description,
+		Visibility:  string(collection.Visibility),
+	})
+}
+
+func (app *application) renderCollection(w http.ResponseWriter, r *http.Request, status int, collection models.Collection, form collectionForm) {
+	snippets, err := app.collectionSnippets(r, collection)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Collection = collection
+	data.Snippets = snippets
+	data.IsOwner = app.ownsCollection(r, collection)
+	data.Form = form
+
+	app.render(w, r, status, "collection.tmpl", data)
+}
+
+// collectionUpdatePost changes the title, description and visibility of a collection.
+func (app *application) collectionUpdatePost(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.ownedCollection(w, r, r.PathValue("slug"))
+	if !ok {
+		return
+	}
+
+	var form collectionForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	checkCollection(&form.Validator, form.Title, form.Description, form.Visibility)
+
+	if !form.Valid() {
+		app.renderCollection(w, r, http.StatusUnprocessableEntity, collection, form)
+		return
+	}
+
+	err = app.collections.Update(collection.ID, form.Title, form.Description, models.Visibility(form.Visibility))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, collection.Path(), http.StatusSeeOther)
+}
+
+func (app *application) collectionDeletePost(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.ownedCollection(w, r, r.PathValue("slug"))
+	if !ok {
+		return
+	}
+
+	err := app.collections.Delete(collection.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, "/user/collections", http.StatusSeeOther)
+}
+
+// snippetCollectPost adds a snippet to the end of one of the visitor's collections,
+// chosen on the snippet's page.
+func (app *application) snippetCollectPost(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.viewableSnippet(w, r, r.PathValue("slug"))
+	if !ok {
+		return
+	}
+
+	var form collectionSnippetForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	collection, ok := app.ownedCollection(w, r, form.Collection)
+	if !ok {
+		return
+	}
+
+	err = app.collections.AddSnippet(collection.ID, snippet.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrCollectionFull) {
+			app.clientError(w, http.StatusUnprocessableEntity)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	http.Redirect(w, r, collection.Path(), http.StatusSeeOther)
+}
+
+func (app *application) collectionRemovePost(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.ownedCollection(w, r, r.PathValue("slug"))
+	if !ok {
+		return
+	}
+
+	var form collectionSnippetForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// Expired snippets can't be looked up any more, but they disappear from collections by themselves.
+	snippet, err := app.snippets.GetBySlug(r.Context(), form.Snippet)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	err = app.collections.RemoveSnippet(collection.ID, snippet.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, collection.Path(), http.StatusSeeOther)
+}
+
+// collectionMovePost moves a snippet one place up or down in a collection, swapping it with its neighbour
+// among the snippets shown on the collection's page.
+func (app *application) collectionMovePost(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.ownedCollection(w, r, r.PathValue("slug"))
+	if !ok {
+		return
+	}
+
+	var form collectionSnippetForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil || (form.Direction != "up" && form.Direction != "down") {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	snippets, err := app.collectionSnippets(r, collection)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	i := slices.IndexFunc(snippets, func(s models.Snippet) bool { return s.Slug == form.Snippet })
+	if i < 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	j := i - 1
+	if form.Direction == "down" {
+		j = i + 1
+	}
+
+	if j >= 0 && j < len(snippets) {
+		snippets[i], snippets[j] = snippets[j], snippets[i]
+
+		ids := make([]int, len(snippets))
+		for k, s := range snippets {
+			ids[k] = s.ID
+		}
+
+		err = app.collections.Reorder(collection.ID, ids)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	http.Redirect(w, r, collection.Path(), http.StatusSeeOther)
+}
+
+// userCollections lists the collections of the current visitor.
+func (app *application) userCollections(w http.ResponseWriter, r *http.Request) {
+	var collections []models.Collection
+
+	if ownerID := app.ownerID(r); ownerID != "" {
+		var err error
+
+		collections, err = app.collections.ByOwner(ownerID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	data := app.newTemplateData(r)
+	data.Collections = collections
+
+	app.render(w, r, http.StatusOK, "collections.tmpl", data)
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// The content codings the compress middleware can use, by their name in Accept-Encoding.
+const (
+	encodingBrotli = "br"
+	encodingZstd   = "zstd"
+	encodingGzip   = "gzip"
+)
+
+// encoder is implemented by the writers of all the content codings.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// The encoders are expensive to create, so they are reused between responses.
+// The levels favour speed, since the pages are compressed again for every request.
+var encoderPools = map[string]*sync.Pool{
+	encodingBrotli: {New: func() any {
+		return brotli.NewWriterLevel(nil, 5)
+	}},
+	encodingZstd: {New: func() any {
+		enc, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault), zstd.WithEncoderConcurrency(1))
+		return enc
+	}},
+	encodingGzip: {New: func() any {
+		enc, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+		return enc
+	}},
+}
+
+// The media types compressed by default: the pages, feeds and API responses, and the text files in ui/static.
+const defaultCompressTypes = "text/html,text/css,text/plain,text/javascript,application/javascript,application/json," +
+	"application/atom+xml,application/rss+xml,application/xml,text/xml,image/svg+xml"
+
+// compression holds the compress_* settings.
+type compression struct {
+	encodings []string
+	minSize   int
+	types     map[string]bool
+}
+
+// parseCompressEncodings parses the compress_encodings setting: the content codings to use,
+// in order of preference, like "br,zstd,gzip".
+func parseCompressEncodings(value string) ([]string, error) {
+	var encodings []string
+
+	for _, s := range strings.Split(value, ",") {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if s == "" {
+			continue
+		}
+
+		if _, ok := encoderPools[s]; !ok {
+			return nil, fmt.Errorf("invalid compress_encodings entry %q (use br, zstd or gzip)", s)
+		}
+		encodings = append(encodings, s)
+	}
+
+	return encodings, nil
+}
+
+// parseCompressTypes parses the compress_types setting: a comma-separated list of media types.
+func parseCompressTypes(value string) map[string]bool {
+	types := make(map[string]bool)
+
+	for _, s := range strings.Split(value, ",") {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if s != "" {
+			types[s] = true
+		}
+	}
+
+	return types
+}
+
+// negotiateEncoding picks the content coding for a response from the request's Accept-Encoding header, like
+// "gzip, deflate, br;q=0.9". The coding with the highest q-value wins, and on a tie the one that comes first in
+// encodings. It returns the empty string if the client accepts none of them.
+func negotiateEncoding(header string, encodings []string) string {
+	accepted := make(map[string]float64)
+
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			q, err = strconv.ParseFloat(v, 64)
+			if err != nil {
+				q = 0
+			}
+		}
+
+		accepted[name] = q
+	}
+
+	best, bestQ := "", 0.0
+
+	for _, encoding := range encodings {
+		q, ok := accepted[encoding]
+		if !ok {
+			q = accepted["*"]
+		}
+
+		if q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+
+	return best
+}
+
+// compress compresses the responses with brotli, zstd or gzip, whichever the client prefers. Only responses
+// with one of the compress_types and at least compress_min_size bytes are compressed: tiny responses would only
+// grow, and images and archives are compressed already. Responses which already have a Content-Encoding, like
+// pre-compressed static files, are passed through untouched, and so are the responses to range requests,
+// since the ranges refer to the uncompressed content. A HEAD request gets the same headers as the GET request
+// would, even when the handler doesn't write the body.
+//
+// It comes before recoverPanic in the middleware chain, so that the 500 page sent after a panic goes through it
+// like any other response.
+func (app *application) compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"), app.compression.encodings)
+		if r.Header.Get("Range") != "" {
+			encoding = ""
+		}
+
+		cw := &compressWriter{
+			ResponseWriter: w,
+			compression:    app.compression,
+			encoding:       encoding,
+			path:           r.URL.Path,
+			head:           r.Method == http.MethodHead,
+		}
+		defer cw.Close()
+
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// compressWriter holds back the start of a response until it knows whether to compress it: that depends on the
+// headers, which can change until the status is written, and on the size of the body. Since app.render writes
+// the status and then the whole page, the decision is usually made on the first Write().
+type compressWriter struct {
+	http.ResponseWriter
+	*compression
+	encoding string
+	path     string
+	head     bool
+
+	status  int
+	buf     []byte
+	decided bool
+	enc     encoder
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	// Informational (1xx) responses are followed by the real one, so they are sent on right away.
+	if cw.decided || status < 200 {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	if cw.status == 0 {
+		cw.status = status
+	}
+
+	// These responses have no body to wait for.
+	if status == http.StatusNoContent || status == http.StatusNotModified {
+		cw.decide(false)
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+
+	if !cw.decided {
+		cw.buf = append(cw.buf, b...)
+
+		if len(cw.buf) < cw.minSize {
+			return len(b), nil
+		}
+
+		err := cw.decide(true)
+		if err != nil {
+			return 0, err
+		}
+
+		return len(b), nil
+	}
+
+	if cw.enc != nil {
+		return cw.enc.Write(b)
+	}
+
+	return cw.ResponseWriter.Write(b)
+}
+
+// decide sends the headers, compressing the response if it qualifies. The body is big enough when it has reached
+// the minimum size, or when the handler flushes it, since a streamed response should be compressed whatever
+// its size. Then it writes what was buffered so far.
+func (cw *compressWriter) decide(bigEnough bool) error {
+	cw.decided = true
+
+	h := cw.Header()
+
+	// Work out the content type now, as the http.ResponseWriter would do it on its own otherwise.
+	contentType := h.Get("Content-Type")
+	if contentType == "" && len(cw.buf) > 0 && h.Get("Content-Encoding") == "" {
+		contentType = http.DetectContentType(cw.buf)
+		h.Set("Content-Type", contentType)
+	}
+	// A 304 Not Modified response has no Content-Type, as http.ServeContent() removes it,
+	// so the type of the file in the URL stands in for it.
+	if contentType == "" && cw.status == http.StatusNotModified {
+		contentType = typeByExtension(path.Ext(cw.path))
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	compressible := cw.types[mediaType] && h.Get("Content-Encoding") == "" && h.Get("Content-Range") == "" &&
+		cw.status != http.StatusNoContent
+
+	// Caches must keep the compressed and the plain responses apart, whichever one this is.
+	if compressible {
+		h.Add("Vary", "Accept-Encoding")
+	}
+
+	// A 304 Not Modified response stands for the response the client would have got, which would have been
+	// compressed, so it gets the same ETag. Otherwise caches would update the weak ETag they hold with a strong one.
+	if compressible && cw.encoding != "" && cw.status == http.StatusNotModified {
+		weakenETag(h)
+	}
+
+	if compressible && cw.encoding != "" && bigEnough && cw.status != http.StatusNotModified {
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+
+		// The ranges would refer to the uncompressed content, so they're no longer on offer.
+		h.Del("Accept-Ranges")
+
+		// The compressed body is a different sequence of bytes, so a strong ETag no longer matches it.
+		weakenETag(h)
+
+		// A HEAD response has the headers of the compressed response, but no body to compress.
+		if !cw.head {
+			cw.enc = encoderPools[cw.encoding].Get().(encoder)
+			cw.enc.Reset(cw.ResponseWriter)
+		}
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	if len(cw.buf) == 0 {
+		return nil
+	}
+
+	var err error
+	if cw.enc != nil {
+		_, err = cw.enc.Write(cw.buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(cw.buf)
+	}
+	cw.buf = nil
+
+	return err
+}
+
+// weakenETag turns a strong ETag into a weak one.
+func weakenETag(h http.Header) {
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.Set("ETag", "W/"+etag)
+	}
+}
+
+// typeByExtension returns the media type of a file extension, like mime.TypeByExtension(), knowing
+// the extensions of the feeds as well.
+func typeByExtension(ext string) string {
+	switch ext {
+	case ".atom":
+		return "application/atom+xml"
+	case ".rss":
+		return "application/rss+xml"
+	default:
+		return mime.TypeByExtension(ext)
+	}
+}
+
+// headBigEnough reports whether the response to a HEAD request which has no body, like the ones from
+// http.ServeContent(), would have been big enough to compress for a GET request: going by its
+// Content-Length if it has one, and otherwise assuming that it would, as most pages are.
+func (cw *compressWriter) headBigEnough() bool {
+	if !cw.head || len(cw.buf) > 0 {
+		return false
+	}
+
+	size, err := strconv.ParseInt(cw.Header().Get("Content-Length"), 10, 64)
+	if err != nil {
+		return true
+	}
+
+	return size >= int64(cw.minSize)
+}
+
+// Close sends whatever is still held back, and finishes the compressed stream.
+func (cw *compressWriter) Close() error {
+	if !cw.decided {
+		// If the handler wrote nothing at all, the server sends its default response.
+		if cw.status == 0 {
+			return nil
+		}
+
+		err := cw.decide(cw.headBigEnough())
+		if err != nil {
+			return err
+		}
+	}
+
+	if cw.enc == nil {
+		return nil
+	}
+
+	err := cw.enc.Close()
+	cw.enc.Reset(nil)
+	encoderPools[cw.encoding].Put(cw.enc)
+	cw.enc = nil
+
+	return err
+}
+
+// Unwrap returns the original http.ResponseWriter, for http.ResponseController.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// Flush sends the response so far to the client, compressing it if it qualifies.
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		if cw.status == 0 {
+			cw.status = http.StatusOK
+		}
+		cw.decide(true)
+	}
+
+	if cw.enc != nil {
+		cw.enc.Flush()
+	}
+
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets the caller take over the connection, if the wrapped http.ResponseWriter supports it.
+// Nothing is compressed after that.
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := cw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+
+	conn, rw, err := hj.Hijack()
+	if err == nil {
+		cw.decided = true
+		cw.buf = nil
+	}
+
+	return conn, rw, err
+}
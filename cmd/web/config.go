@@ -0,0 +1,330 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"snippetbox.t10i.net/internal/ratelimit"
+	"snippetbox.t10i.net/internal/settings"
+)
+
+// config holds the settings of the application. Every setting is defined as a command-line flag, and can
+// also be given in a configuration file or in a SNIPPETBOX_* environment variable, as loaded by the
+// internal/settings package.
+type config struct {
+	configFile      string
+	addr            string
+	adminAddr       string
+	drainDelay      time.Duration
+	shutdownTimeout time.Duration
+	dsn             string
+	dsnFile         string
+	legacyIDs       string
+	secretPolicy    string
+	blobStore       string
+	blobDir         string
+	s3Endpoint      string
+	s3Bucket        string
+	s3Region        string
+	frameAncestors  string
+	masterKeyFile   string
+	prevMasterKey   string
+	analyticsKey    string
+	logSample       float64
+	slowRequest     time.Duration
+	logFormat       string
+	logLevel        *slog.LevelVar
+	logFile         string
+	logMaxSize      int64
+	logMaxBackups   int
+	traceExporter   string
+	traceEndpoint   string
+	traceSample     float64
+	rateRead        ratelimit.Limit
+	rateWrite       ratelimit.Limit
+	rateLogin       ratelimit.Limit
+	rateAPI         ratelimit.Limit
+	rateKey         string
+	rateStore       string
+	trustedProxies  string
+	forwardedHeader string
+	compressEncs    string
+	compressMinSize int
+	compressTypes   string
+
+	// flags holds the definitions of the settings, and sources records where each value came from.
+	flags   *flag.FlagSet
+	sources settings.Sources
+}
+
+// newConfig defines the settings of the application on a new flag.FlagSet. Like the default FlagSet
+// used by flag.Parse(), it exits the program if the command line can't be parsed.
+func newConfig(name string) *config {
+	cfg := &config{
+		logLevel: new(slog.LevelVar),
+		flags:    flag.NewFlagSet(name, flag.ExitOnError),
+	}
+
+	fs := cfg.flags
+
+	// Define a flag for the configuration file. It can also be set in the SNIPPETBOX_CONFIG environment
+	// variable, but not in the file itself.
+	fs.StringVar(&cfg.configFile, "config", "", "Configuration file (.toml, .yaml or .json)")
+
+	// Define a new command-line flag with the name 'addr', a default value of ":4000"
+	// and some short help text explaining what the flag controls.
+	// The value of the flag will be stored in the the cfg.addr field at runtime.
+	fs.StringVar(&cfg.addr, "addr", ":4000", "HTTP server network address")
+
+	// Define a flag for the address of the admin listener, which serves /metrics and /log-level.
+	// It defaults to localhost, so that the metrics aren't exposed to the world by accident.
+	fs.StringVar(&cfg.adminAddr, "admin-addr", "localhost:4001", "Admin HTTP server network address, for metrics and the log level (empty to disable)")
+
+	// Define flags for the graceful shutdown. During the drain delay /readyz already reports 503,
+	// but requests are still served, which gives load balancers time to take the instance out of rotation.
+	fs.DurationVar(&cfg.drainDelay, "drain-delay", 5*time.Second, "How long to keep serving with /readyz failing before shutting down")
+	fs.DurationVar(&cfg.shutdownTimeout, "shutdown-timeout", 20*time.Second, "How long to wait for requests in flight when shutting down")
+
+	// Define a new command-line flag for the MySQL DSN string. Because the DSN contains the database
+	// password, it can also be read from a file instead, like a mounted Docker or Kubernetes secret.
+	fs.StringVar(&cfg.dsn, "dsn", "web:normaluser@/snippetbox?parseTime=true", "MySQL data source name")
+	fs.StringVar(&cfg.dsnFile, "dsn-file", "", "File containing the MySQL data source name, instead of -dsn")
+
+	// Define a flag which controls what happens to the old numeric /snippet/view/{id} URLs.
+	fs.StringVar(&cfg.legacyIDs, "legacy-ids", legacyIDsRedirect, "How to handle numeric snippet URLs (redirect|404)")
+
+	// Define a flag which controls what happens when a new snippet seems to contain credentials.
+	fs.StringVar(&cfg.secretPolicy, "secret-policy", secretPolicyWarn, "What to do with secrets in new snippets (warn|redact|off)")
+
+	// Define flags for where attachments are stored. The S3 credentials are read from the
+	// AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables, so they don't show up in ps.
+	fs.StringVar(&cfg.blobStore, "blob-store", "local", "Where to store attachments (local|s3)")
+	fs.StringVar(&cfg.blobDir, "blob-dir", "./uploads", "Directory for attachments, with -blob-store=local")
+	fs.StringVar(&cfg.s3Endpoint, "s3-endpoint", "", "S3-compatible endpoint URL, with -blob-store=s3")
+	fs.StringVar(&cfg.s3Bucket, "s3-bucket", "", "S3 bucket for attachments")
+	fs.StringVar(&cfg.s3Region, "s3-region", "us-east-1", "S3 region")
+
+	// Define a flag listing the sites which may show snippets in an iframe, like an internal wiki.
+	fs.StringVar(&cfg.frameAncestors, "frame-ancestors", "", "Origins allowed to embed snippets, like https://wiki.example.com (default: this site only)")
+
+	// Define flags for the master keys that encrypt snippet content at rest. The keys can also be set
+	// in the SNIPPETBOX_MASTER_KEY and SNIPPETBOX_PREVIOUS_MASTER_KEY environment variables.
+	fs.StringVar(&cfg.masterKeyFile, "master-key-file", "", "File containing the base64-encoded master key")
+	fs.StringVar(&cfg.prevMasterKey, "previous-master-key-file", "", "File containing the previous master key, while rotating keys")
+
+	// Define a flag for the key that the hashes of visitors are made with, for counting unique visitors.
+	// It must be the same on every instance and across restarts, or a visitor is counted again each time.
+	// It can also be set in the SNIPPETBOX_ANALYTICS_KEY environment variable.
+	fs.StringVar(&cfg.analyticsKey, "analytics-key-file", "", "File containing the base64-encoded key for hashing visitors")
+
+	// Define flags for the access log. Successful requests can be sampled to keep the log small,
+	// but client and server errors and slow requests are always logged.
+	fs.Float64Var(&cfg.logSample, "log-sample", 1, "Fraction of successful requests to write to the access log (0-1)")
+	fs.DurationVar(&cfg.slowRequest, "slow-request", time.Second, "Requests taking at least this long are logged as slow (0 to disable)")
+
+	// Define flags for the application log. The level can also be changed while the application runs,
+	// through the /log-level endpoint of the admin listener or (on Unix) by sending the process SIGUSR1.
+	fs.StringVar(&cfg.logFormat, "log-format", logFormatText, "Log format (text|json)")
+	fs.Var(levelFlag{cfg.logLevel}, "log-level", "Minimum log level (debug|info|warn|error)")
+	fs.StringVar(&cfg.logFile, "log-file", "", "Write the log to this file instead of standard out")
+	fs.Int64Var(&cfg.logMaxSize, "log-max-size", 100, "Rotate the log file when it grows past this many megabytes")
+	fs.IntVar(&cfg.logMaxBackups, "log-max-backups", 5, "How many rotated log files to keep")
+
+	// Define flags for tracing. The OTLP exporter also reads the standard OTEL_EXPORTER_OTLP_* environment
+	// variables, like OTEL_EXPORTER_OTLP_HEADERS for the credentials of a hosted collector.
+	fs.StringVar(&cfg.traceExporter, "trace-exporter", traceExporterNone, "Where to send traces (none|otlp|stdout)")
+	fs.StringVar(&cfg.traceEndpoint, "trace-endpoint", "", "OTLP/HTTP endpoint URL, like http://localhost:4318/v1/traces (default: from OTEL_EXPORTER_OTLP_ENDPOINT)")
+	fs.Float64Var(&cfg.traceSample, "trace-sample", 1, "Fraction of new traces to record (0-1)")
+
+	// Define flags for the load balancers and reverse proxies in front of the application. Only their headers
+	// are believed to find the real client, and only the one they maintain: X-Forwarded-For (with X-Forwarded-Proto)
+	// or Forwarded. Whatever the client sent in the other one is passed on untouched, and would be a lie.
+	fs.StringVar(&cfg.trustedProxies, "trusted-proxies", "", "Comma-separated CIDR ranges of trusted reverse proxies, like 10.0.0.0/8")
+	fs.StringVar(&cfg.forwardedHeader, "forwarded-header", forwardedHeaderXFF, "Header the trusted proxies pass the client on in (x-forwarded-for|forwarded)")
+
+	// Define flags for the compression of responses. An empty list of encodings turns compression off.
+	fs.StringVar(&cfg.compressEncs, "compress-encodings", "br,zstd,gzip", "Content codings to compress responses with, in order of preference")
+	fs.IntVar(&cfg.compressMinSize, "compress-min-size", 1024, "Smallest response in bytes worth compressing")
+	fs.StringVar(&cfg.compressTypes, "compress-types", defaultCompressTypes, "Comma-separated media types to compress")
+
+	// Define flags for the rate limits. Each policy is a token bucket per client, written as "<count>/<period>":
+	// a client can make count requests at once, and then gets count more over the period. "off" disables it.
+	cfg.rateRead, _ = ratelimit.ParseLimit("600/1m")
+	cfg.rateWrite, _ = ratelimit.ParseLimit("30/1m")
+	cfg.rateLogin, _ = ratelimit.ParseLimit("10/1m")
+	cfg.rateAPI, _ = ratelimit.ParseLimit("300/1m")
+	fs.Var(limitFlag{&cfg.rateRead}, "rate-read", "Rate limit of page views and other GET requests")
+	fs.Var(limitFlag{&cfg.rateWrite}, "rate-write", "Rate limit of form posts, like creating snippets")
+	fs.Var(limitFlag{&cfg.rateLogin}, "rate-login", "Rate limit of attempts at snippet passwords")
+	fs.Var(limitFlag{&cfg.rateAPI}, "rate-api", "Rate limit of JSON API requests")
+	fs.StringVar(&cfg.rateKey, "rate-key", rateKeyIP, "Count requests by client IP, or by client IP and also by owner ID where there is one (ip|user)")
+	fs.StringVar(&cfg.rateStore, "rate-store", rateStoreMemory, "Where to keep the rate limit buckets; mysql shares them between instances (memory|mysql)")
+
+	return cfg
+}
+
+// loadConfig builds the configuration from the defaults, the configuration file, the environment and
+// the command-line arguments. Values which were given on the command line are never overridden, so the
+// command line is parsed first, and then the file and the environment only fill in the other settings.
+func loadConfig(name string, args []string) (*config, error) {
+	cfg := newConfig(name)
+
+	var err error
+
+	cfg.sources, err = settings.Load(cfg.flags, args, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read the DSN from its file, if there is one.
+	err = settings.LoadFromFile(cfg.flags, cfg.sources, "dsn")
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// validate checks that the settings make sense together, so that the application fails at startup
+// instead of when a request first needs a setting. All the problems are reported at once.
+func (cfg *config) validate() error {
+	var errs []error
+
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(cfg.addr != "", "addr must not be empty")
+	check(cfg.dsn != "", "dsn must not be empty")
+	check(cfg.drainDelay >= 0, "drain_delay must not be negative")
+	check(cfg.shutdownTimeout > 0, "shutdown_timeout must be positive")
+	check(cfg.legacyIDs == legacyIDsRedirect || cfg.legacyIDs == legacyIDsNotFound,
+		"invalid legacy_ids value %q (use redirect or 404)", cfg.legacyIDs)
+	check(cfg.secretPolicy == secretPolicyWarn || cfg.secretPolicy == secretPolicyRedact || cfg.secretPolicy == secretPolicyOff,
+		"invalid secret_policy value %q (use warn, redact or off)", cfg.secretPolicy)
+	check(cfg.blobStore == "local" || cfg.blobStore == "s3",
+		"invalid blob_store value %q (use local or s3)", cfg.blobStore)
+	check(cfg.blobStore != "s3" || cfg.s3Bucket != "", "s3_bucket must be set with blob_store s3")
+	check(cfg.logSample >= 0 && cfg.logSample <= 1, "log_sample must be between 0 and 1")
+	check(cfg.logFormat == logFormatText || cfg.logFormat == logFormatJSON,
+		"invalid log_format value %q (use text or json)", cfg.logFormat)
+	check(cfg.logMaxSize > 0, "log_max_size must be positive")
+	check(cfg.logMaxBackups >= 0, "log_max_backups must not be negative")
+	check(cfg.traceExporter == traceExporterNone || cfg.traceExporter == traceExporterOTLP || cfg.traceExporter == traceExporterStdout,
+		"invalid trace_exporter value %q (use none, otlp or stdout)", cfg.traceExporter)
+	check(cfg.traceSample >= 0 && cfg.traceSample <= 1, "trace_sample must be between 0 and 1")
+	check(cfg.compressMinSize >= 0, "compress_min_size must not be negative")
+	check(cfg.rateKey == rateKeyIP || cfg.rateKey == rateKeyUser, "invalid rate_key value %q (use ip or user)", cfg.rateKey)
+	check(cfg.forwardedHeader == forwardedHeaderXFF || cfg.forwardedHeader == forwardedHeaderForwarded,
+		"invalid forwarded_header value %q (use x-forwarded-for or forwarded)", cfg.forwardedHeader)
+	check(cfg.rateStore == rateStoreMemory || cfg.rateStore == rateStoreMySQL,
+		"invalid rate_store value %q (use memory or mysql)", cfg.rateStore)
+
+	_, err := parseFrameAncestors(cfg.frameAncestors)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	_, err = parseTrustedProxies(cfg.trustedProxies)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	_, err = parseCompressEncodings(cfg.compressEncs)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	if cfg.traceEndpoint != "" {
+		u, err := url.Parse(cfg.traceEndpoint)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+			"trace_endpoint must be an http or https URL")
+	}
+
+	return errors.Join(errs...)
+}
+
+// redactors hide the secret parts of the settings which contain them, for `config print`.
+var redactors = map[string]func(string) string{
+	"dsn": redactDSN,
+}
+
+// redactDSN replaces the password in a MySQL DSN. If the DSN can't be parsed, all of it is hidden,
+// since there's no telling where the password is.
+func redactDSN(dsn string) string {
+	c, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return "REDACTED"
+	}
+
+	if c.Passwd != "" {
+		c.Passwd = "REDACTED"
+	}
+
+	return c.FormatDSN()
+}
+
+// print writes the effective configuration in the format of a TOML configuration file, with the source of
+// every value in a comment. Secrets are redacted.
+func (cfg *config) print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	cfg.flags.VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+
+		if redact, ok := redactors[f.Name]; ok && value != "" {
+			value = redact(value)
+		}
+
+		key := strings.ReplaceAll(f.Name, "-", "_")
+		fmt.Fprintf(tw, "%s = %s\t# %s\n", key, strconv.Quote(value), cfg.sources.Get(f.Name))
+	})
+
+	return tw.Flush()
+}
+
+// configCommand runs the `config` subcommand, and returns the exit status. The only action is
+// `config print`, which takes the same flags as the server:
+//
+//	web config print -config=snippetbox.toml
+//
+// It prints the configuration and any validation errors, so it can be used to check a configuration
+// file before deploying it.
+func configCommand(args []string) int {
+	if len(args) == 0 || args[0] != "print" {
+		fmt.Fprintln(os.Stderr, "usage: web config print [flags]")
+		return 2
+	}
+
+	cfg, err := loadConfig("config print", args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	err = cfg.print(os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	err = cfg.validate()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
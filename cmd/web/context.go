@@ -0,0 +1,15 @@
+package main
+
+// Define a custom contextKey type, rather than using a plain string,
+// so that our keys can never collide with keys set by third-party packages.
+type contextKey string
+
+// ownerIDContextKey is the key under which the identify middleware stores the
+// (hashed) owner ID of the current visitor in the request context.
+const ownerIDContextKey = contextKey("ownerID")
+
+// requestIDContextKey is the key under which the requestID middleware stores the ID of the current request.
+const requestIDContextKey = contextKey("requestID")
+
+// clientContextKey is the key under which the resolveClient middleware stores the IP address and scheme of the client.
+const clientContextKey = contextKey("client")
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"snippetbox.t10i.net/internal/models"
+)
+
+// The size of the iframe suggested by the oEmbed endpoint, unless the consumer asks for a smaller one.
+const (
+	embedWidth  = 600
+	embedHeight = 400
+)
+
+// parseFrameAncestors turns the value of the -frame-ancestors flag, a list of origins separated by
+// spaces or commas, into the source list of a CSP frame-ancestors directive. Each entry must be
+// 'self', 'none' or a scheme and host (with an optional port or leading *. wildcard), like https://wiki.example.com.
+// An empty value allows only Snippetbox itself to frame the embed page.
+func parseFrameAncestors(value string) (string, error) {
+	fields := strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+
+	if len(fields) == 0 {
+		return "'self'", nil
+	}
+
+	for _, field := range fields {
+		if field == "'self'" || field == "'none'" {
+			continue
+		}
+
+		u, err := url.Parse(field)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+			(u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" || u.User != nil ||
+			strings.ContainsAny(field, ";'\"") {
+			return "", fmt.Errorf("invalid -frame-ancestors entry %q", field)
+		}
+	}
+
+	return strings.Join(fields, " "), nil
+}
+
+// allowFraming replaces the framing headers set by commonHeaders, so that the pages of the wrapped
+// handler can be shown in an iframe by the sites in the -frame-ancestors allowlist, and only by them.
+func (app *application) allowFraming(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// X-Frame-Options can't express an allowlist, and browsers that support frame-ancestors ignore it anyway.
+		w.Header().Del("X-Frame-Options")
+		w.Header().Set("Content-Security-Policy", contentSecurityPolicy+"; frame-ancestors "+app.frameAncestors)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// snippetEmbed shows a snippet on its own, without the rest of the site, for use in an iframe.
+// Private snippets can't be embedded, and protected ones only show a link to their unlock form.
+func (app *application) snippetEmbed(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.viewableSnippet(w, r, r.PathValue("slug"))
+	if !ok {
+		return
+	}
+
+	if snippet.Visibility == models.VisibilityPrivate {
+		http.NotFound(w, r)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Snippet = snippet
+
+	// The content of protected snippets stays hidden, even if the visitor has unlocked it before.
+	if !snippet.Protected() {
+		app.countView(r, snippet, models.ViewKindPage)
+	}
+
+	app.renderLayout(w, r, http.StatusOK, "embed.tmpl", "embed", data)
+}
+
+// oEmbedResponse is the JSON representation of a rich oEmbed response (https://oembed.com/).
+type oEmbedResponse struct {
+	Version      string `json:"version"`
+	Type         string `json:"type"`
+	Title        string `json:"title"`
+	ProviderName string `json:"provider_name"`
+	ProviderURL  string `json:"provider_url"`
+	HTML         string `json:"html"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+}
+
+// oEmbed answers oEmbed requests for the URL of a snippet, like /oembed?url=https://.../snippet/view/{slug},
+// with the HTML of an iframe showing its embed page. Only the JSON format is supported.
+func (app *application) oEmbed(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	if format := query.Get("format"); format != "" && format != "json" {
+		app.clientError(w, http.StatusNotImplemented)
+		return
+	}
+
+	slug, fragment, ok := app.oEmbedSlug(r, query.Get("url"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	// oEmbed consumers usually fetch this from their own servers, without any cookies,
+	// so there's no owner to check against: private snippets are simply not found.
+	snippet, err := app.snippets.GetBySlug(r.Context(), slug)
+	if err == nil && snippet.Visibility == models.VisibilityPrivate {
+		err = models.ErrNoRecord
+	}
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	width := oEmbedDimension(query.Get("maxwidth"), embedWidth)
+	height := oEmbedDimension(query.Get("maxheight"), embedHeight)
+
+	// The fragment of an encrypted snippet's URL holds its key, which the embed page needs too.
+	src := baseURL(r) + "/snippet/embed/" + snippet.Slug
+	if fragment != "" {
+		src += "#" + fragment
+	}
+
+	resp := oEmbedResponse{
+		Version:      "1.0",
+		Type:         "rich",
+		Title:        snippet.Title,
+		ProviderName: "Snippetbox",
+		ProviderURL:  baseURL(r) + "/",
+		HTML: fmt.Sprintf(`<iframe src="%s" width="%d" height="%d" title="%s" style="border:0" loading="lazy"></iframe>`,
+			template.HTMLEscapeString(src), width, height, template.HTMLEscapeString(snippet.Title)),
+		Width:  width,
+		Height: height,
+	}
+
+	js, err := json.Marshal(resp)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
+
+// oEmbedSlug returns the slug of the snippet that a URL points to, along with the URL's fragment.
+// Only the view and embed pages of this site are recognised.
+func (app *application) oEmbedSlug(r *http.Request, rawURL string) (slug, fragment string, ok bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil || !strings.EqualFold(u.Host, r.Host) {
+		return "", "", false
+	}
+
+	for _, prefix := range []string{"/snippet/view/", "/snippet/embed/"} {
+		if slug, found := strings.CutPrefix(u.Path, prefix); found && len(slug) == models.SlugLength {
+			return slug, u.Fragment, true
+		}
+	}
+
+	return "", "", false
+}
+
+// oEmbedDimension returns the given default, or the consumer's maximum if that's smaller.
+func oEmbedDimension(max string, def int) int {
+	n, err := strconv.Atoi(max)
+	if err != nil || n < 1 || n > def {
+		return def
+	}
+
+	return n
+}
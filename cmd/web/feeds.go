@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"snippetbox.t10i.net/internal/models"
+)
+
+// The feed formats, which are also the file extensions of the feed URLs.
+const (
+	feedAtom = "atom"
+	feedRSS  = "rss"
+)
+
+// The longest excerpt of a snippet's content included in a feed entry, in characters.
+const feedExcerptChars = 280
+
+// feedTokenRX matches the feed tokens used in the URLs of per-user feeds.
+var feedTokenRX = regexp.MustCompile(`^[A-Za-z0-9_-]{` + strconv.Itoa(models.FeedTokenLength) + `}$`)
+
+// feed holds what the Atom and RSS feeds are generated from.
+type feed struct {
+	Title    string
+	Link     string
+	Self     string
+	Snippets []models.Snippet
+}
+
+// The atomFeed, atomEntry and atomLink types are marshalled into an Atom 1.0 document (RFC 4287).
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Author  string      `xml:"author>name"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	Title     string    `xml:"title"`
+	ID        string    `xml:"id"`
+	Published string    `xml:"published"`
+	Updated   string    `xml:"updated"`
+	Link      atomLink  `xml:"link"`
+	Summary   *atomText `xml:"summary,omitempty"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// The rssFeed, rssChannel and rssItem types are marshalled into an RSS 2.0 document.
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+	Description string  `xml:"description,omitempty"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// feedLatest serves the feeds of the latest public snippets, /feed.atom and /feed.rss.
+func (app *application) feedLatest(format string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		snippets, err := app.snippets.Latest(r.Context())
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		base := baseURL(r)
+
+		app.serveFeed(w, r, format, feed{
+			Title:    "Latest snippets",
+			Link:     base + "/",
+			Self:     base + r.URL.Path,
+			Snippets: snippets,
+		})
+	}
+}
+
+// feedLanguage serves the feed of the latest public snippets in one language, like /feed/language/go.atom.
+// Snippets have no tags of their own, so their language is what these feeds are grouped by.
+func (app *application) feedLanguage(w http.ResponseWriter, r *http.Request) {
+	language, format, ok := feedName(r.PathValue("name"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	name, ok := models.Languages[language]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	snippets, err := app.snippets.LatestByLanguage(r.Context(), language)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	base := baseURL(r)
+
+	app.serveFeed(w, r, format, feed{
+		Title:    "Latest " + name + " snippets",
+		Link:     base + "/",
+		Self:     base + r.URL.Path,
+		Snippets: snippets,
+	})
+}
+
+// feedUser serves the feed of the latest public snippets of one owner, like /feed/user/<feed token>.atom.
+// The URL holds a random feed token rather than the owner ID, which is what identifies the owner everywhere
+// else. Owners create the token, and replace it to revoke the old link, on the page of their starred snippets.
+func (app *application) feedUser(w http.ResponseWriter, r *http.Request) {
+	token, format, ok := feedName(r.PathValue("name"))
+	if !ok || !feedTokenRX.MatchString(token) {
+		http.NotFound(w, r)
+		return
+	}
+
+	ownerID, err := app.feedTokens.Owner(token)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	snippets, err := app.snippets.LatestByOwner(r.Context(), ownerID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	base := baseURL(r)
+
+	app.serveFeed(w, r, format, feed{
+		Title:    "Latest snippets by one author",
+		Link:     base + "/",
+		Self:     base + r.URL.Path,
+		Snippets: snippets,
+	})
+}
+
+// feedName splits the last segment of a feed URL, like "go.atom", into its name and format.
+func feedName(segment string) (name, format string, ok bool) {
+	i := strings.LastIndexByte(segment, '.')
+	if i < 1 {
+		return "", "", false
+	}
+
+	name, format = segment[:i], segment[i+1:]
+	if format != feedAtom && format != feedRSS {
+		return "", "", false
+	}
+
+	return name, format, true
+}
+
+// serveFeed writes a feed in the given format. The ETag is a hash of the generated document,
+// and Last-Modified is the time the newest snippet was created, so that feed readers polling
+// with If-None-Match or If-Modified-Since get a 304 Not Modified response when nothing has changed.
+func (app *application) serveFeed(w http.ResponseWriter, r *http.Request, format string, f feed) {
+	var updated time.Time
+	for _, s := range f.Snippets {
+		if s.CreatedAt.After(updated) {
+			updated = s.CreatedAt
+		}
+	}
+
+	var (
+		doc         any
+		contentType string
+	)
+
+	switch format {
+	case feedAtom:
+		doc, contentType = atomDocument(f, updated), "application/atom+xml; charset=utf-8"
+	default:
+		doc, contentType = rssDocument(f, updated), "application/rss+xml; charset=utf-8"
+	}
+
+	buf := new(bytes.Buffer)
+	buf.WriteString(xml.Header)
+
+	enc := xml.NewEncoder(buf)
+	enc.Indent("", "  ")
+
+	err := enc.Encode(doc)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	sum := sha256.Sum256(buf.Bytes())
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	w.Header().Set("Cache-Control", "public, max-age=300")
+
+	// http.ServeContent() takes care of the conditional request headers.
+	// A zero modification time, for an empty feed, leaves out Last-Modified.
+	http.ServeContent(w, r, "", updated, bytes.NewReader(buf.Bytes()))
+}
+
+func atomDocument(f feed, updated time.Time) atomFeed {
+	doc := atomFeed{
+		Title:   f.Title,
+		ID:      f.Self,
+		Updated: atomTime(updated),
+		Author:  "Snippetbox",
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: f.Self},
+			{Rel: "alternate", Type: "text/html", Href: f.Link},
+		},
+	}
+
+	base := strings.TrimSuffix(f.Link, "/")
+
+	for _, s := range f.Snippets {
+		entry := atomEntry{
+			Title:     s.Title,
+			ID:        base + s.Path(),
+			Published: atomTime(s.CreatedAt),
+			Updated:   atomTime(s.CreatedAt),
+			Link:      atomLink{Rel: "alternate", Type: "text/html", Href: base + s.Path()},
+		}
+
+		if excerpt := feedExcerpt(s); excerpt != "" {
+			entry.Summary = &atomText{Type: "text", Body: excerpt}
+		}
+
+		doc.Entries = append(doc.Entries, entry)
+	}
+
+	return doc
+}
+
+func rssDocument(f feed, updated time.Time) rssFeed {
+	doc := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:       f.Title,
+			Link:        f.Link,
+			Description: f.Title + " on Snippetbox",
+		},
+	}
+
+	if !updated.IsZero() {
+		doc.Channel.LastBuildDate = updated.UTC().Format(time.RFC1123Z)
+	}
+
+	base := strings.TrimSuffix(f.Link, "/")
+
+	for _, s := range f.Snippets {
+		doc.Channel.Items = append(doc.Channel.Items, rssItem{
+			Title:       s.Title,
+			Link:        base + s.Path(),
+			GUID:        rssGUID{IsPermaLink: true, Value: base + s.Path()},
+			PubDate:     s.CreatedAt.UTC().Format(time.RFC1123Z),
+			Description: feedExcerpt(s),
+		})
+	}
+
+	return doc
+}
+
+// atomTime formats a time as an RFC 3339 date, as Atom requires. A feed without entries
+// has no newest snippet, so it's dated at the Unix epoch rather than with an empty string.
+func atomTime(t time.Time) string {
+	if t.IsZero() {
+		t = time.Unix(0, 0)
+	}
+
+	return t.UTC().Format(time.RFC3339)
+}
+
+// feedExcerpt returns the start of the content of a snippet's first file, as plain text. The XML encoder escapes it.
+// Protected and encrypted snippets get no excerpt, because a feed would give away what the password
+// or key is there to protect.
+func feedExcerpt(s models.Snippet) string {
+	if s.Protected() || s.Encrypted {
+		return ""
+	}
+
+	content := s.Content
+	if utf8.RuneCountInString(content) <= feedExcerptChars {
+		return content
+	}
+
+	runes := []rune(content)
+
+	return string(runes[:feedExcerptChars]) + "…"
+}
+
+// baseURL returns the scheme and host that the request was made to, for the absolute links in feeds.
+// Behind a TLS-terminating proxy the scheme is the one the client used, as told by the proxy.
+func baseURL(r *http.Request) string {
+	return fmt.Sprintf("%s://%s", clientScheme(r), r.Host)
+}
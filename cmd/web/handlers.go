@@ -1,12 +1,18 @@
 package main
 
 import (
+	"archive/zip"
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"mime"
 	"net/http"
 	"strconv"
 
 	"snippetbox.t10i.net/internal/models"
+	"snippetbox.t10i.net/internal/storage"
 	"snippetbox.t10i.net/internal/validator"
 )
 
@@ -15,15 +21,50 @@ import (
 // So, for example, here we're telling the decoder to store the value from the HTML form
 // input with the name "title" in the Title field.
 // The struct tag `form:"-"` tells the decoder to completely ignore a field during decoding.
+//
+// The files of a snippet are decoded from inputs named like "files[0].name", "files[1].content" and so on.
 type snippetCreateForm struct {
-	Title               string `form:"title"`
-	Content             string `form:"content"`
-	ExpiresAt           int    `form:"expires_at"`
+	Title               string            `form:"title"`
+	Files               []snippetFileForm `form:"files"`
+	ExpiresAt           int               `form:"expires_at"`
+	Visibility          string            `form:"visibility"`
+	Password            string            `form:"password"`
+	Encrypted           bool              `form:"encrypted"`
+	PublishAnyway       bool              `form:"publish_anyway"`
+	ForkedFrom          string            `form:"forked_from"`
+	SecretsFound        bool              `form:"-"`
+	validator.Validator `form:"-"`
+}
+
+// snippetFileForm holds one of the files on the create form.
+type snippetFileForm struct {
+	Name     string `form:"name"`
+	Language string `form:"language"`
+	Content  string `form:"content"`
+}
+
+// commentForm holds a new comment on a snippet. ParentID is 0 for a top-level comment, and Line is 0
+// for a comment that isn't anchored to a line of the file at FileIndex.
+type commentForm struct {
+	Author              string `form:"author"`
+	Body                string `form:"body"`
+	ParentID            int    `form:"parent_id"`
+	FileIndex           int    `form:"file_index"`
+	Line                int    `form:"line"`
+	validator.Validator `form:"-"`
+}
+
+// The most files a single snippet can hold.
+const maxSnippetFiles = 10
+
+// snippetUnlockForm holds the password entered on the unlock page of a protected snippet.
+type snippetUnlockForm struct {
+	Password            string `form:"password"`
 	validator.Validator `form:"-"`
 }
 
 func (app *application) home(w http.ResponseWriter, r *http.Request) {
-	snippets, err := app.snippets.Latest()
+	snippets, err := app.snippets.Latest(r.Context())
 	if err != nil {
 		app.serverError(w, r, err)
 		return
@@ -40,27 +81,644 @@ func (app *application) home(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *application) snippetView(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("slug")
+
+	// Snippets used to be addressed by their sequential ID. Depending on the -legacy-ids setting,
+	// an old numeric URL for a public snippet is either redirected to its slug or treated as not found.
+	if len(key) != models.SlugLength {
+		app.snippetViewLegacy(w, r, key)
+		return
+	}
+
+	snippet, ok := app.viewableSnippet(w, r, key)
+	if !ok {
+		return
+	}
+
+	// Protected snippets show an unlock form instead of their content,
+	// until the visitor has entered the right password.
+	if !app.isUnlocked(r, snippet) {
+		data := app.newTemplateData(r)
+		data.Snippet = snippet
+		data.Form = snippetUnlockForm{}
+		app.render(w, r, http.StatusOK, "unlock.tmpl", data)
+		return
+	}
+
+	data, err := app.snippetViewData(r, snippet)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// A "Reply" link on a comment sets the reply query string parameter,
+	// which pre-fills the comment form with the comment being replied to.
+	replyTo, _ := strconv.Atoi(r.URL.Query().Get("reply"))
+	data.Form = commentForm{ParentID: replyTo}
+
+	app.countView(r, snippet, models.ViewKindPage)
+
+	app.render(w, r, http.StatusOK, "view.tmpl", data)
+}
+
+// The number of top-level comments (each with all of its replies) shown per page.
+const commentsPerPage = 20
+
+// snippetViewData gathers everything that view.tmpl shows about a snippet, apart from the comment form.
+func (app *application) snippetViewData(r *http.Request, snippet models.Snippet) (templateData, error) {
+	attachments, err := app.attachments.ForSnippet(snippet.ID)
+	if err != nil {
+		return templateData{}, err
+	}
+
+	forks, err := app.snippets.Forks(r.Context(), snippet.ID)
+	if err != nil {
+		return templateData{}, err
+	}
+
+	page := pageNumber(r)
+
+	comments, total, err := app.comments.Threads(snippet.ID, page, commentsPerPage)
+	if err != nil {
+		return templateData{}, err
+	}
+
+	data := app.newTemplateData(r)
+	data.Snippet = snippet
+	data.Attachments = attachments
+	data.Forks = forks
+	data.Comments = comments
+	data.Pagination = newPagination(page, total, commentsPerPage)
+	data.IsOwner = app.isOwner(r, snippet)
+
+	if ownerID := app.ownerID(r); ownerID != "" {
+		data.Starred, err = app.stars.Exists(ownerID, snippet.ID)
+		if err != nil {
+			return templateData{}, err
+		}
+
+		// The visitor's collections, for the form which adds the snippet to one of them.
+		data.Collections, err = app.collections.ByOwner(ownerID)
+		if err != nil {
+			return templateData{}, err
+		}
+	}
+
+	// Link to the original of a fork, but only if doing so doesn't reveal the link to a snippet
+	// that isn't public (unless it's the visitor's own).
+	if snippet.ForkedFrom != 0 {
+		original, err := app.snippets.GetOriginal(r.Context(), snippet)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			return templateData{}, err
+		}
+
+		if err == nil && (original.Visibility == models.VisibilityPublic || app.isOwner(r, original)) {
+			data.Original = original
+		}
+	}
+
+	return data, nil
+}
+
+// snippetViewLegacy handles the old /snippet/view/{id} URLs.
+func (app *application) snippetViewLegacy(w http.ResponseWriter, r *http.Request, key string) {
+	id, err := strconv.Atoi(key)
+	if err != nil || id < 1 || app.legacyIDs != legacyIDsRedirect {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Get() only ever returns public snippets, so a numeric ID can't be used to reach a non-public one.
+	snippet, err := app.snippets.Get(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+
+		return
+	}
+
+	http.Redirect(w, r, snippet.Path(), http.StatusMovedPermanently)
+}
+
+func (app *application) snippetUnlockPost(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.viewableSnippet(w, r, r.PathValue("slug"))
+	if !ok {
+		return
+	}
+
+	if !snippet.Protected() {
+		http.Redirect(w, r, snippet.Path(), http.StatusSeeOther)
+		return
+	}
+
+	// Refuse to even look at the password once this client has tried too many for this snippet,
+	// so that the bcrypt comparison can't be used to brute-force it.
+	limitKey := unlockLimitKey(snippet.Slug, clientIP(r))
+
+	allowed, retryAfter := app.unlockLimiter.Attempt(limitKey)
+	if !allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+		app.clientError(w, http.StatusTooManyRequests)
+		return
+	}
+
+	var form snippetUnlockForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
+
+	if form.Valid() {
+		match, err := snippet.CheckPassword(form.Password)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		if !match {
+			form.AddFieldError("password", "Wrong password")
+		}
+	}
+
+	if !form.Valid() {
+		form.Password = ""
+		data := app.newTemplateData(r)
+		data.Snippet = snippet
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "unlock.tmpl", data)
+		return
+	}
+
+	app.unlockLimiter.Reset(limitKey)
+	app.unlock(w, snippet)
+
+	http.Redirect(w, r, snippet.Path(), http.StatusSeeOther)
+}
+
+// checkSecrets applies the -secret-policy to the content of a new snippet's files. With the "warn" policy
+// it adds a field error, unless the user has explicitly ticked "publish anyway";
+// with the "redact" policy it replaces the secrets in the content.
+func (app *application) checkSecrets(form *snippetCreateForm) {
+	if app.secretPolicy == secretPolicyOff {
+		return
+	}
+
+	for i := range form.Files {
+		file := &form.Files[i]
+
+		findings := validator.ScanSecrets(file.Content)
+		if len(findings) == 0 {
+			continue
+		}
+
+		switch app.secretPolicy {
+		case secretPolicyRedact:
+			file.Content = validator.RedactSecrets(file.Content, findings)
+		case secretPolicyWarn:
+			form.SecretsFound = true
+			form.CheckField(form.PublishAnyway, fileFieldKey(i, "content"),
+				fmt.Sprintf("This looks like it contains secrets (%s). Remove them, or tick \"publish anyway\"", validator.SecretKinds(findings)))
+		}
+	}
+}
+
+// fileFieldKey returns the FieldErrors key for a field of the i'th file on the create form.
+func fileFieldKey(i int, field string) string {
+	return fmt.Sprintf("files.%d.%s", i, field)
+}
+
+// checkFiles validates the files of a new snippet, and fills in any language that was left to be detected.
+func checkFiles(form *snippetCreateForm) {
+	form.CheckField(len(form.Files) > 0, "files", "A snippet needs at least one file")
+	form.CheckField(len(form.Files) <= maxSnippetFiles, "files", fmt.Sprintf("A snippet cannot have more than %d files", maxSnippetFiles))
+
+	names := make(map[string]bool)
+
+	for i := range form.Files {
+		file := &form.Files[i]
+
+		// Every file needs a name, so that it can be put in a zip archive.
+		form.CheckField(validator.NotBlank(file.Name), fileFieldKey(i, "name"), "This field cannot be blank")
+		form.CheckField(validator.MaxChars(file.Name, 255), fileFieldKey(i, "name"), "This field cannot be more than 255 characters long")
+		form.CheckField(validator.Matches(file.Name, validator.FileNameRX), fileFieldKey(i, "name"),
+			"This field can only contain letters, digits, dots, dashes and underscores")
+		form.CheckField(file.Name != "." && file.Name != "..", fileFieldKey(i, "name"), "This field cannot be . or ..")
+		form.CheckField(!names[file.Name], fileFieldKey(i, "name"), "Another file already has this name")
+		names[file.Name] = true
+
+		_, ok := models.Languages[file.Language]
+		form.CheckField(ok, fileFieldKey(i, "language"), "This field must be one of the listed languages")
+		if file.Language == "" {
+			file.Language = models.DetectLanguage(file.Name)
+		}
+
+		form.CheckField(validator.NotBlank(file.Content), fileFieldKey(i, "content"), "This field cannot be blank")
+
+		// Encrypted content arrives as base64-encoded ciphertext. If it doesn't look like that,
+		// the browser didn't encrypt it (most likely because JavaScript is disabled), and we must not store it.
+		if form.Encrypted {
+			form.CheckField(validator.Matches(file.Content, validator.CiphertextRX), fileFieldKey(i, "content"),
+				"This snippet could not be encrypted in your browser, which needs JavaScript enabled")
+		}
+	}
+}
+
+// snippetDownload sends all the files of a snippet as a zip archive.
+func (app *application) snippetDownload(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.viewableSnippet(w, r, r.PathValue("slug"))
+	if !ok {
+		return
+	}
+
+	// Send visitors who haven't entered the password yet to the unlock form.
+	if !app.isUnlocked(r, snippet) {
+		http.Redirect(w, r, snippet.Path(), http.StatusSeeOther)
+		return
+	}
+
+	// The server only has the ciphertext of encrypted snippets, which is of no use in an archive.
+	if snippet.Encrypted {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Build the whole archive in memory first, so that an error can still be reported with a 500 response.
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+
+	for _, file := range snippet.Files {
+		f, err := zw.Create(file.Name)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		_, err = f.Write([]byte(file.Content))
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	err := zw.Close()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, snippet.Slug))
+	buf.WriteTo(w)
+}
+
+// snippetRaw sends the content of a single file of a snippet as plain text.
+// The file is picked by the file query string parameter, and defaults to the first one.
+func (app *application) snippetRaw(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.viewableSnippet(w, r, r.PathValue("slug"))
+	if !ok {
+		return
+	}
+
+	if !app.isUnlocked(r, snippet) {
+		http.Redirect(w, r, snippet.Path(), http.StatusSeeOther)
+		return
+	}
+
+	// Without the key from the URL fragment, the raw content of an encrypted snippet is just ciphertext.
+	if snippet.Encrypted || len(snippet.Files) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	file := snippet.Files[0]
+	if name := r.URL.Query().Get("file"); name != "" {
+		found := false
+		for _, f := range snippet.Files {
+			if f.Name == name {
+				file, found = f, true
+				break
+			}
+		}
+
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	app.countView(r, snippet, models.ViewKindRaw)
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Write([]byte(file.Content))
+}
+
+// The number of days shown on the analytics page, and the number of referrers listed there.
+const (
+	analyticsDays      = 30
+	analyticsReferrers = 10
+)
+
+// snippetAnalytics shows the owner of a snippet how often it was viewed, and from where.
+// To everyone else the page doesn't exist.
+func (app *application) snippetAnalytics(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.viewableSnippet(w, r, r.PathValue("slug"))
+	if !ok {
+		return
+	}
+
+	if !app.isOwner(r, snippet) {
+		http.NotFound(w, r)
+		return
+	}
+
+	daily, err := app.analytics.Daily(snippet.ID, analyticsDays)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	referrers, err := app.analytics.TopReferrers(snippet.ID, analyticsDays, analyticsReferrers)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Snippet = snippet
+	data.Daily = daily
+	data.Referrers = referrers
+
+	app.render(w, r, http.StatusOK, "analytics.tmpl", data)
+}
+
+// snippetAttachment sends a file attached to a snippet. It's always sent as a download,
+// and sandboxed by its Content-Security-Policy in case a browser decides to display it anyway.
+func (app *application) snippetAttachment(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.viewableSnippet(w, r, r.PathValue("slug"))
+	if !ok {
+		return
+	}
+
+	if !app.isUnlocked(r, snippet) {
+		http.Redirect(w, r, snippet.Path(), http.StatusSeeOther)
+		return
+	}
+
 	id, err := strconv.Atoi(r.PathValue("id"))
 	if err != nil || id < 1 {
 		http.NotFound(w, r)
 		return
 	}
 
-	snippet, err := app.snippets.Get(id)
+	attachment, err := app.attachments.Get(snippet.ID, id)
 	if err != nil {
 		if errors.Is(err, models.ErrNoRecord) {
 			http.NotFound(w, r)
 		} else {
 			app.serverError(w, r, err)
 		}
+		return
+	}
+
+	// The blob can be missing if it was removed from the store by hand, or if an upload never finished.
+	blob, err := app.blobs.Get(r.Context(), attachment.BlobKey)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	defer blob.Close()
+
+	w.Header().Set("Content-Type", attachmentContentType(attachment))
+	w.Header().Set("Content-Length", strconv.FormatInt(attachment.Size, 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Name}))
+	w.Header().Set("Content-Security-Policy", "sandbox")
+
+	io.Copy(w, blob)
+}
 
+// snippetFork shows the create form, pre-filled with the files of an existing snippet.
+func (app *application) snippetFork(w http.ResponseWriter, r *http.Request) {
+	original, ok := app.viewableSnippet(w, r, r.PathValue("slug"))
+	if !ok {
+		return
+	}
+
+	if !app.isUnlocked(r, original) {
+		http.Redirect(w, r, original.Path(), http.StatusSeeOther)
+		return
+	}
+
+	// The server can't pre-fill the form with the content of an encrypted snippet, as it only has the ciphertext.
+	if original.Encrypted {
+		http.NotFound(w, r)
 		return
 	}
 
+	files := make([]snippetFileForm, len(original.Files))
+	for i, file := range original.Files {
+		files[i] = snippetFileForm{Name: file.Name, Language: file.Language, Content: file.Content}
+	}
+
+	// The fork starts out with the same visibility as the original,
+	// so that forking an unlisted snippet doesn't accidentally publish it.
 	data := app.newTemplateData(r)
-	data.Snippet = snippet
+	data.Form = snippetCreateForm{
+		Title:      original.Title,
+		Files:      files,
+		ExpiresAt:  365,
+		Visibility: string(original.Visibility),
+		ForkedFrom: original.Slug,
+	}
 
-	app.render(w, r, http.StatusOK, "view.tmpl", data)
+	app.render(w, r, http.StatusOK, "create.tmpl", data)
+}
+
+func (app *application) snippetCommentPost(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.viewableSnippet(w, r, r.PathValue("slug"))
+	if !ok {
+		return
+	}
+
+	// Commenting on a protected snippet requires having unlocked it, just like reading it does.
+	if !app.isUnlocked(r, snippet) {
+		http.Redirect(w, r, snippet.Path(), http.StatusSeeOther)
+		return
+	}
+
+	var form commentForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Author), "author", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Author, 50), "author", "This field cannot be more than 50 characters long")
+	form.CheckField(validator.NotBlank(form.Body), "body", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Body, 5000), "body", "This field cannot be more than 5000 characters long")
+	form.CheckField(form.FileIndex >= 0 && form.FileIndex < len(snippet.Files), "line", "This file does not exist")
+
+	// The content of encrypted snippets can't be split into lines on the server, so any line is accepted.
+	if form.FileIndex >= 0 && form.FileIndex < len(snippet.Files) && !snippet.Encrypted {
+		lineCount := len(lines(snippet.Files[form.FileIndex].Content))
+		form.CheckField(form.Line >= 0 && form.Line <= lineCount, "line", fmt.Sprintf("This field must be between 0 and %d", lineCount))
+	}
+	form.CheckField(form.Line >= 0, "line", "This field cannot be negative")
+
+	if form.ParentID != 0 {
+		_, err := app.comments.Get(snippet.ID, form.ParentID)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			app.serverError(w, r, err)
+			return
+		}
+		form.CheckField(err == nil, "body", "The comment you are replying to no longer exists")
+	}
+
+	if !form.Valid() {
+		data, err := app.snippetViewData(r, snippet)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "view.tmpl", data)
+		return
+	}
+
+	ownerID, err := app.ensureOwnerID(w, r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	id, err := app.comments.Insert(snippet.ID, form.ParentID, form.FileIndex, form.Line, form.Author, ownerID, form.Body)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("%s#comment-%d", snippet.Path(), id), http.StatusSeeOther)
+}
+
+// snippetStarPost and snippetUnstarPost are idempotent: starring a starred snippet,
+// or unstarring one that isn't starred, simply leaves it as it is.
+func (app *application) snippetStarPost(w http.ResponseWriter, r *http.Request) {
+	app.setStar(w, r, true)
+}
+
+func (app *application) snippetUnstarPost(w http.ResponseWriter, r *http.Request) {
+	app.setStar(w, r, false)
+}
+
+func (app *application) setStar(w http.ResponseWriter, r *http.Request, starred bool) {
+	snippet, ok := app.viewableSnippet(w, r, r.PathValue("slug"))
+	if !ok {
+		return
+	}
+
+	ownerID, err := app.ensureOwnerID(w, r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if starred {
+		err = app.stars.Star(ownerID, snippet.ID)
+	} else {
+		err = app.stars.Unstar(ownerID, snippet.ID)
+	}
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, snippet.Path(), http.StatusSeeOther)
+}
+
+// The number of snippets shown per page of the starred list.
+const starredPerPage = 20
+
+// userStarred lists the snippets the current visitor has starred.
+func (app *application) userStarred(w http.ResponseWriter, r *http.Request) {
+	var (
+		snippets []models.Snippet
+		total    int
+		page     = pageNumber(r)
+	)
+
+	// Visitors without an owner cookie haven't starred anything yet.
+	if ownerID := app.ownerID(r); ownerID != "" {
+		var err error
+
+		snippets, total, err = app.snippets.StarredBy(r.Context(), ownerID, page, starredPerPage)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	// Owners who have created a link to their feed see it here.
+	var feedToken string
+	if ownerID := app.ownerID(r); ownerID != "" {
+		var err error
+
+		feedToken, err = app.feedTokens.Get(ownerID)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	data := app.newTemplateData(r)
+	data.Snippets = snippets
+	data.Pagination = newPagination(page, total, starredPerPage)
+	data.FeedToken = feedToken
+
+	app.render(w, r, http.StatusOK, "starred.tmpl", data)
+}
+
+// userFeedTokenPost gives the visitor a new link to the feed of their own snippets. It replaces the old link,
+// if they had one, which stops working: that's how a link which was shared too widely is revoked.
+func (app *application) userFeedTokenPost(w http.ResponseWriter, r *http.Request) {
+	ownerID, err := app.ensureOwnerID(w, r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	_, err = app.feedTokens.Reset(ownerID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, "/user/starred", http.StatusSeeOther)
+}
+
+// userFeedTokenDeletePost revokes the link to the visitor's feed, without giving them a new one.
+func (app *application) userFeedTokenDeletePost(w http.ResponseWriter, r *http.Request) {
+	if ownerID := app.ownerID(r); ownerID != "" {
+		err := app.feedTokens.Delete(ownerID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	http.Redirect(w, r, "/user/starred", http.StatusSeeOther)
 }
 
 func (app *application) snippetCreate(w http.ResponseWriter, r *http.Request) {
@@ -70,42 +728,130 @@ func (app *application) snippetCreate(w http.ResponseWriter, r *http.Request) {
 	// Notice how this is also a great opportunity to set any default or 'initial' values for the form
 	// --- here we set the initial value for the snippet expiry to 365 days.
 	data.Form = snippetCreateForm{
-		ExpiresAt: 365,
+		Files:      []snippetFileForm{{}},
+		ExpiresAt:  365,
+		Visibility: string(models.VisibilityPublic),
 	}
 
 	app.render(w, r, http.StatusOK, "create.tmpl", data)
 }
 
 func (app *application) snippetCreatePost(w http.ResponseWriter, r *http.Request) {
+	// Limit the size of the request body, attachments included. Reading past the limit makes
+	// decodePostForm() return a *http.MaxBytesError, which we report as 413 Content Too Large.
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+
+	// Forms with attachments are sent as multipart/form-data. ParseMultipartForm() keeps at most maxMultipartMemory
+	// bytes of the files in memory, and writes the rest to temporary files, which we remove once we're done.
+	// Forms without attachments are sent url-encoded, and left to decodePostForm().
+	err := r.ParseMultipartForm(maxMultipartMemory)
+	if r.MultipartForm != nil {
+		defer r.MultipartForm.RemoveAll()
+	}
+	if errors.Is(err, http.ErrNotMultipart) {
+		err = nil
+	}
+
 	var form snippetCreateForm
 
-	err := app.decodePostForm(r, &form)
+	if err == nil {
+		err = app.decodePostForm(r, &form)
+	}
 	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			app.clientError(w, http.StatusRequestEntityTooLarge)
+			return
+		}
+
 		app.clientError(w, http.StatusBadRequest)
 		return
 	}
 
 	form.CheckField(validator.NotBlank(form.Title), "title", "This field cannot be blank")
 	form.CheckField(validator.MaxChars(form.Title, 100), "title", "This field cannot be more than 100 characters long")
-	form.CheckField(validator.NotBlank(form.Content), "content", "This field cannot be blank")
 	form.CheckField(validator.PermittedValue(form.ExpiresAt, 1, 7, 365), "expires_at", "This field must equal 1, 7 or 365")
+	form.CheckField(validator.PermittedValue(models.Visibility(form.Visibility),
+		models.VisibilityPublic, models.VisibilityUnlisted, models.VisibilityPrivate),
+		"visibility", "This field must equal public, unlisted or private")
+
+	checkFiles(&form)
+	uploads := checkAttachments(&form, r)
+
+	// Resolve the slug of the snippet this one was forked from, checking that the user is still allowed to see it.
+	var forkedFrom int
+	if form.ForkedFrom != "" {
+		original, err := app.snippets.GetBySlug(r.Context(), form.ForkedFrom)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			app.serverError(w, r, err)
+			return
+		}
+
+		form.CheckField(err == nil && app.canView(r, original) && app.isUnlocked(r, original),
+			"forked_from", "The snippet you are forking no longer exists")
+		forkedFrom = original.ID
+	}
+
+	// Look for credentials that were pasted by accident. Encrypted content is skipped,
+	// because the server can't read it (and ciphertext always looks like a high-entropy secret).
+	if !form.Encrypted {
+		app.checkSecrets(&form)
+	}
+
+	// The password is optional, but bcrypt only looks at the first 72 bytes of it.
+	if form.Password != "" {
+		form.CheckField(validator.MinChars(form.Password, 8), "password", "This field must be at least 8 characters long")
+		form.CheckField(validator.MaxBytes(form.Password, 72), "password", "This field cannot be more than 72 bytes long")
+	}
 
 	// Use the Valid() method to see if any of the checks failed.
 	// If they did, then re-render the template passing in the form in the same way as before.
 	if !form.Valid() {
+		// Never send the password back to the browser. Neither do we send back encrypted content,
+		// as its key only ever existed in the browser that submitted the form.
+		form.Password = ""
+		if form.Encrypted {
+			for i := range form.Files {
+				form.Files[i].Content = ""
+			}
+		}
 		data := app.newTemplateData(r)
 		data.Form = form
 		app.render(w, r, http.StatusUnprocessableEntity, "create.tmpl", data)
 		return
 	}
 
-	// Pass the data to the SnippetModel.Insert() method, receiving the ID of the new record back.
-	id, err := app.snippets.Insert(form.Title, form.Content, form.ExpiresAt)
+	// Every snippet records who created it, so that private snippets can be shown to their owner.
+	ownerID, err := app.ensureOwnerID(w, r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	files := make([]models.File, len(form.Files))
+	for i, file := range form.Files {
+		files[i] = models.File{Name: file.Name, Language: file.Language, Content: file.Content}
+	}
+
+	// Pass the data to the SnippetModel.Insert() method, receiving the new snippet back.
+	snippet, err := app.snippets.Insert(r.Context(), form.Title, files, form.ExpiresAt, models.Visibility(form.Visibility), ownerID, form.Password, form.Encrypted, forkedFrom)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
 
+	// The blob keys of the attachments start with the slug, so they can only be stored once the snippet exists.
+	// If that fails, the snippet is deleted again: otherwise it would be left without its attachments,
+	// and trying again would create a second copy of it.
+	err = app.storeAttachments(r.Context(), snippet, uploads)
+	if err != nil {
+		deleteErr := app.snippets.Delete(context.WithoutCancel(r.Context()), snippet.ID)
+		app.serverError(w, r, errors.Join(err, deleteErr))
+		return
+	}
+
+	app.metrics.snippetsCreated.Inc()
+
 	// Redirect the user to the relevant page for the snippet.
-	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", id), http.StatusSeeOther)
+	http.Redirect(w, r, snippet.Path(), http.StatusSeeOther)
 }
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// How long /readyz waits for the database to answer a ping.
+const readyDBTimeout = 2 * time.Second
+
+// The statuses reported by the health checks.
+const (
+	checkOK          = "ok"
+	checkUnavailable = "unavailable"
+)
+
+// healthCheck is the result of one check in a /healthz or /readyz response.
+type healthCheck struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// healthz reports that the process is alive and able to handle requests. It checks nothing else,
+// so that a database outage doesn't make the orchestrator restart every instance.
+func (app *application) healthz(w http.ResponseWriter, r *http.Request) {
+	app.writeHealth(w, r, map[string]healthCheck{
+		"process": {Status: checkOK},
+	})
+}
+
+// readyz reports whether the instance should receive traffic: the database answers, the templates
+// were loaded, and the server isn't draining before a shutdown. It responds with 503 Service Unavailable
+// if any of those checks fails.
+func (app *application) readyz(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]healthCheck{
+		"database":  {Status: checkOK},
+		"templates": {Status: checkOK},
+		"shutdown":  {Status: checkOK},
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readyDBTimeout)
+	defer cancel()
+
+	// The probes can be reached by anyone, so the details of the error only go to the log.
+	err := app.db.PingContext(ctx)
+	if err != nil {
+		app.logger.WarnContext(r.Context(), "readiness check failed", "check", "database", "error", err.Error())
+		checks["database"] = healthCheck{Status: checkUnavailable, Error: "database did not answer the ping"}
+	}
+
+	if _, ok := app.templateCache["home.tmpl"]; !ok {
+		checks["templates"] = healthCheck{Status: checkUnavailable, Error: "template cache is not loaded"}
+	}
+
+	if app.draining.Load() {
+		checks["shutdown"] = healthCheck{Status: checkUnavailable, Error: "server is draining"}
+	}
+
+	app.writeHealth(w, r, checks)
+}
+
+// writeHealth sends the results of the checks, with an overall status that is only ok if every check is.
+func (app *application) writeHealth(w http.ResponseWriter, r *http.Request, checks map[string]healthCheck) {
+	status, code := checkOK, http.StatusOK
+
+	for _, check := range checks {
+		if check.Status != checkOK {
+			status, code = checkUnavailable, http.StatusServiceUnavailable
+		}
+	}
+
+	// Probes must always see the current state, never a cached one.
+	w.Header().Set("Cache-Control", "no-store")
+
+	app.writeJSON(w, r, code, jsonObject{"status": status, "checks": checks})
+}
@@ -2,6 +2,9 @@ package main
 
 import (
 	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net/http"
@@ -9,6 +12,10 @@ import (
 	"time"
 
 	"github.com/go-playground/form"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"snippetbox.t10i.net/internal/models"
 )
 
 // The serverError helper writes a log entry at Error level
@@ -24,9 +31,16 @@ func (app *application) serverError(w http.ResponseWriter, r *http.Request, err
 		trace = string(debug.Stack())
 	)
 
-	// Include the trace in the log entry.
-	app.logger.Error(err.Error(), "method", method, "uri", uri, "trace", trace)
-	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	// Include the trace in the log entry. Logging with the request context adds the request ID to it.
+	app.logger.ErrorContext(r.Context(), err.Error(), "method", method, "uri", uri, "trace", trace)
+
+	// Show the request ID on the error page too, so that a user reporting the error can quote it.
+	message := http.StatusText(http.StatusInternalServerError)
+	if id, ok := r.Context().Value(requestIDContextKey).(string); ok {
+		message += "\n\nRequest ID: " + id
+	}
+
+	http.Error(w, message, http.StatusInternalServerError)
 }
 
 // The clientError helper sends a specific status code and corresponding desc to the user.
@@ -37,6 +51,11 @@ func (app *application) clientError(w http.ResponseWriter, status int) {
 }
 
 func (app *application) render(w http.ResponseWriter, r *http.Request, status int, page string, data templateData) {
+	app.renderLayout(w, r, status, page, "base", data)
+}
+
+// renderLayout renders a page inside the given layout, like "base" or the bare "embed" layout for iframes.
+func (app *application) renderLayout(w http.ResponseWriter, r *http.Request, status int, page, layout string, data templateData) {
 	// Retrieve the appropriate template set from the cache based on the page name (like 'home.tmpl').
 	// If no entry exists in the cache with the provided name,
 	// then create a new error and call the serverError() helper method that we made earlier and return.
@@ -47,13 +66,20 @@ func (app *application) render(w http.ResponseWriter, r *http.Request, status in
 		return
 	}
 
+	// Record the rendering in a span of its own, so that a trace shows how much of a slow request
+	// went to the templates rather than the database.
+	_, span := tracer.Start(r.Context(), "render "+page, trace.WithAttributes(attribute.String("template.layout", layout)))
+	defer span.End()
+
 	// Init a new buffer
 	buf := new(bytes.Buffer)
 
 	// Write the template to the buffer, instead of straight to the http.ResponseWriter.
 	// If there's an error, call our serverError() helper and then return.
-	err := ts.ExecuteTemplate(buf, "base", data)
+	err := ts.ExecuteTemplate(buf, layout, data)
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 		app.serverError(w, r, err)
 		return
 	}
@@ -73,14 +99,17 @@ func (app *application) render(w http.ResponseWriter, r *http.Request, status in
 func (app *application) newTemplateData(r *http.Request) templateData {
 	return templateData{
 		CurrentYear: time.Now().Year(),
+		Languages:   models.Languages,
+		BaseURL:     baseURL(r),
 	}
 }
 
 // Create a new decodePostForm() helper method.
 // The second parameter here, dst, is the target destination that we want to decode the form data into.
 func (app *application) decodePostForm(r *http.Request, dst any) error {
-	// Call ParseForm() on the request,
-	// in the same way that we did in our snippetCreatePost handler.
+	// Call ParseForm() on the request, in the same way that we did in our snippetCreatePost handler.
+	// It reads at most 10MB of the body. The create form, which takes file uploads, parses its
+	// multipart body itself first, and then ParseForm() leaves the r.PostForm that it filled in alone.
 	err := r.ParseForm()
 	if err != nil {
 		return err
@@ -105,3 +134,86 @@ func (app *application) decodePostForm(r *http.Request, dst any) error {
 
 	return nil
 }
+
+// The name of the long-lived cookie that ties a browser to the snippets it created.
+const ownerCookieName = "owner"
+
+// ownerID returns the hashed owner ID of the current visitor,
+// or an empty string if they have never created a snippet.
+func (app *application) ownerID(r *http.Request) string {
+	ownerID, _ := r.Context().Value(ownerIDContextKey).(string)
+	return ownerID
+}
+
+// ensureOwnerID returns the owner ID of the current visitor, issuing a new owner cookie first if they don't have one yet.
+// Only a hash of the cookie value is stored in the database, so a leaked database row can't be used to impersonate the owner.
+func (app *application) ensureOwnerID(w http.ResponseWriter, r *http.Request) (string, error) {
+	if ownerID := app.ownerID(r); ownerID != "" {
+		return ownerID, nil
+	}
+
+	b := make([]byte, 32)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	token := hex.EncodeToString(b)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     ownerCookieName,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int((365 * 24 * time.Hour).Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return hashOwnerToken(token), nil
+}
+
+// hashOwnerToken returns the hex-encoded SHA-256 hash of an owner cookie value.
+func hashOwnerToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// isOwner reports whether the current visitor created the snippet.
+// Snippets from before owners were recorded have an empty owner ID, and belong to nobody.
+func (app *application) isOwner(r *http.Request, snippet models.Snippet) bool {
+	ownerID := app.ownerID(r)
+
+	return ownerID != "" && ownerID == snippet.OwnerID
+}
+
+// canView reports whether the current visitor is allowed to see the snippet.
+// Private snippets are only visible to the visitor who created them.
+func (app *application) canView(r *http.Request, snippet models.Snippet) bool {
+	if snippet.Visibility != models.VisibilityPrivate {
+		return true
+	}
+
+	return app.isOwner(r, snippet)
+}
+
+// viewableSnippet fetches the snippet with the given slug. If it doesn't exist, or the current visitor
+// isn't allowed to see it, a 404 Not Found (or a 500 for any other error) is sent and ok is false.
+func (app *application) viewableSnippet(w http.ResponseWriter, r *http.Request, slug string) (snippet models.Snippet, ok bool) {
+	snippet, err := app.snippets.GetBySlug(r.Context(), slug)
+	if err == nil && !app.canView(r, snippet) {
+		err = models.ErrNoRecord
+	}
+
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+
+		return models.Snippet{}, false
+	}
+
+	return snippet, true
+}
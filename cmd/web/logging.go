@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+	"snippetbox.t10i.net/internal/logfile"
+)
+
+// The values accepted by the log_format setting.
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// newLogger creates the application logger, writing in the given format to the given file
+// (or to standard out if path is empty). The file is rotated once it grows past maxSize bytes.
+// The level of the logger is read from level, so that it can be changed while the application runs.
+// The returned io.Closer closes the log file.
+func newLogger(format string, level *slog.LevelVar, path string, maxSize int64, maxBackups int) (*slog.Logger, io.Closer, error) {
+	var (
+		out    io.Writer = os.Stdout
+		closer io.Closer = io.NopCloser(nil)
+	)
+
+	if path != "" {
+		f, err := logfile.Open(path, maxSize, maxBackups)
+		if err != nil {
+			return nil, nil, err
+		}
+		out, closer = f, f
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	switch format {
+	case logFormatText:
+		handler = slog.NewTextHandler(out, opts)
+	case logFormatJSON:
+		handler = slog.NewJSONHandler(out, opts)
+	default:
+		closer.Close()
+		return nil, nil, fmt.Errorf("invalid -log-format value %q", format)
+	}
+
+	// The handler is wrapped to add the request ID to entries logged with a request context.
+	return slog.New(newContextHandler(handler)), closer, nil
+}
+
+// levelFlag is a flag.Value which sets a slog.LevelVar, for the log_level setting.
+// flag.TextVar() can't be used for it, since a slog.LevelVar can't be copied as its default value.
+type levelFlag struct {
+	level *slog.LevelVar
+}
+
+func (f levelFlag) String() string {
+	// The flag package calls String() on a zero levelFlag to find out whether a default is set.
+	if f.level == nil {
+		return ""
+	}
+	return strings.ToLower(f.level.Level().String())
+}
+
+func (f levelFlag) Set(s string) error {
+	return f.level.UnmarshalText([]byte(s))
+}
+
+// contextHandler is a slog.Handler which adds the ID of the current request to every log entry
+// written with one of the *Context methods of the logger, like app.logger.ErrorContext(r.Context(), ...).
+// That way all the entries about one request can be found by its ID. The IDs of the trace and span
+// are added too, to go from a log entry to the trace of the request.
+type contextHandler struct {
+	slog.Handler
+}
+
+func newContextHandler(h slog.Handler) slog.Handler {
+	return contextHandler{Handler: h}
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id, ok := ctx.Value(requestIDContextKey).(string); ok {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		record.AddAttrs(slog.String("trace_id", sc.TraceID().String()), slog.String("span_id", sc.SpanID().String()))
+	}
+
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+// logLevelHandler shows the current log level with GET, and changes it with PUT and a body
+// like {"level": "debug"}. It's served on the admin listener.
+func (app *application) logLevelHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut {
+		var input struct {
+			Level string `json:"level"`
+		}
+
+		err := app.readJSON(w, r, &input)
+		if err != nil {
+			app.errorJSON(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		var level slog.Level
+
+		err = level.UnmarshalText([]byte(input.Level))
+		if err != nil {
+			app.errorJSON(w, r, http.StatusUnprocessableEntity, jsonObject{"level": "This field must equal debug, info, warn or error"})
+			return
+		}
+
+		app.setLogLevel(level, "admin endpoint")
+	}
+
+	app.writeJSON(w, r, http.StatusOK, jsonObject{"level": strings.ToLower(app.logLevel.Level().String())})
+}
+
+// setLogLevel changes the level of the application logger, and logs who changed it.
+func (app *application) setLogLevel(level slog.Level, source string) {
+	previous := app.logLevel.Level()
+	app.logLevel.Set(level)
+
+	// Log at Warn level, so that the change shows up whatever the old and new levels are.
+	app.logger.Warn("log level changed", "from", previous.String(), "to", level.String(), "by", source)
+}
@@ -1,16 +1,23 @@
 package main
 
 import (
+	"crypto/rand"
 	"database/sql"
-	"flag"
+	"errors"
+	"fmt"
 	"html/template"
 	"log/slog"
-	"net/http"
+	"net/netip"
 	"os"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-playground/form"
 	_ "github.com/go-sql-driver/mysql"
+	"snippetbox.t10i.net/internal/envelope"
 	"snippetbox.t10i.net/internal/models"
+	"snippetbox.t10i.net/internal/ratelimit"
+	"snippetbox.t10i.net/internal/storage"
 )
 
 // Define an application struct to hold the application-wide dependencies for the web app.
@@ -19,46 +26,146 @@ import (
 // Add a templateCache field to the application struct.
 // Add a formDecoder field to hold a pointer to a form.Decoder instance.
 type application struct {
-	logger        *slog.Logger
-	snippets      *models.SnippetModel
-	templateCache map[string]*template.Template
-	formDecoder   *form.Decoder
+	logger         *slog.Logger
+	snippets       *models.SnippetModel
+	templateCache  map[string]*template.Template
+	formDecoder    *form.Decoder
+	legacyIDs      string
+	secretKey      []byte
+	visitorKey     []byte
+	unlockLimiter  *unlockLimiter
+	secretPolicy   string
+	attachments    *models.AttachmentModel
+	comments       *models.CommentModel
+	stars          *models.StarModel
+	feedTokens     *models.FeedTokenModel
+	blobs          storage.BlobStore
+	analytics      *models.AnalyticsModel
+	views          *viewCounter
+	frameAncestors string
+	collections    *models.CollectionModel
+	metrics        *metrics
+	db             *sql.DB
+	draining       atomic.Bool
+	logSample      float64
+	slowRequest    time.Duration
+	logLevel       *slog.LevelVar
+	rateLimiter    *rateLimiter
+	trustedProxies []netip.Prefix
+	proxyHeader    string
+	compression    *compression
 }
 
+// The values accepted by the legacy_ids setting.
+const (
+	legacyIDsRedirect = "redirect"
+	legacyIDsNotFound = "404"
+)
+
+// The values accepted by the secret_policy setting.
+const (
+	secretPolicyWarn   = "warn"
+	secretPolicyRedact = "redact"
+	secretPolicyOff    = "off"
+)
+
 func main() {
-	// Define a new command-line flag with the name 'addr', a default value of ":4000"
-	// and some short help text explaining what the flag controls.
-	// The value of the flag will be stored in the the addr variable at runtime.
-	addr := flag.String("addr", ":4000", "HTTP server network address")
-
-	// Define a new command-line flag for the MySQL DSN string.
-	dsn := flag.String("dsn", "web:normaluser@/snippetbox?parseTime=true", "MySQL data source name")
-
-	// Importantly, we use the flag.Parse() function to parse the command-line flag.
-	// This reads in the command-line flag value and assigns it to the addr variable.
-	// You need to call this *before* you use the addr variable
-	// otherwise it will always contain the default value of ":4000".
-	// If any errors are encountered during parsing the application will be terminated.
-	flag.Parse()
-
-	// Use the slog.New() function to initialize a new structured logger,
-	// which writes to the standard out stream and uses the default settings.
-	loggerHandler := slog.NewTextHandler(os.Stdout, nil)
-	logger := slog.New(loggerHandler)
+	// The config subcommand prints the effective configuration instead of starting the server.
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(configCommand(os.Args[2:]))
+	}
+
+	// Load the configuration from the defaults, the configuration file, SNIPPETBOX_* environment
+	// variables and the command-line flags. See config.go for the settings.
+	cfg, err := loadConfig(os.Args[0], os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	// Check all the settings before starting anything.
+	err = cfg.validate()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	// Use the newLogger() helper to initialize a new structured logger, as set up by the log_* settings.
+	// Until it exists, errors can only be written to standard error.
+	logger, logCloser, err := newLogger(cfg.logFormat, cfg.logLevel, cfg.logFile, cfg.logMaxSize<<20, cfg.logMaxBackups)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer logCloser.Close()
+
+	// Set up the export of traces. The spans still waiting to be exported are flushed when main() returns.
+	shutdownTracing, err := setupTracing(cfg.traceExporter, cfg.traceEndpoint, cfg.traceSample)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	defer shutdownTracing()
+
+	// These settings were already checked by cfg.validate().
+	frameAncestorsCSP, _ := parseFrameAncestors(cfg.frameAncestors)
+	trustedProxies, _ := parseTrustedProxies(cfg.trustedProxies)
+	compressEncodings, _ := parseCompressEncodings(cfg.compressEncs)
 
 	// To keep the main() function tidy
-	// I've put the code for creating a connection pool into the separate openDB() function below.
-	// We pass openDB() the DSN from the command-line flag.
-	db, err := openDB(*dsn)
+	// I've put the code for creating a connection pool into the models.OpenDB() function,
+	// which the rotatekeys command uses too. We pass it the DSN from the configuration.
+	db, err := models.OpenDB(cfg.dsn)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	blobs, err := openBlobStore(cfg.blobStore, cfg.blobDir, cfg.s3Endpoint, cfg.s3Bucket, cfg.s3Region)
 	if err != nil {
 		logger.Error(err.Error())
 		os.Exit(1)
 	}
 
+	// Load the master keys. Running without one is allowed for local development,
+	// but then snippet content is stored in plain text.
+	keys, err := envelope.LoadKeyring(cfg.masterKeyFile, cfg.prevMasterKey)
+	if err != nil {
+		if !errors.Is(err, envelope.ErrNoKey) {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		logger.Warn("no master key configured, snippet content will not be encrypted at rest")
+	}
+
+	// Load the key for the visitor hashes. Without one, a random key is used, which counts every
+	// visitor again after a restart and on every instance.
+	visitorKey, err := envelope.LoadKey(cfg.analyticsKey, analyticsKeyEnv)
+	if err != nil {
+		if !errors.Is(err, envelope.ErrNoKey) {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		logger.Warn("no analytics key configured, unique visitors are counted again after every restart")
+
+		visitorKey = make([]byte, envelope.KeySize)
+		_, err = rand.Read(visitorKey)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+	}
+
 	// We also defer a call to db.Close(),
 	// so that the connection pool is closed before the main() function exits.
 	defer db.Close()
 
+	// Keep the rate limit buckets in memory, unless they should be shared with the other instances.
+	var rateStore ratelimit.Store = ratelimit.NewMemoryStore()
+	if cfg.rateStore == rateStoreMySQL {
+		rateStore = &ratelimit.MySQLStore{DB: db}
+	}
+
 	// Initialize a new template cache.
 	templateCache, err := newTemplateCache()
 	if err != nil {
@@ -66,47 +173,92 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Generate a random key for signing the cookies which remember unlocked snippets.
+	// Because it changes on every restart, visitors simply have to enter the password again afterwards.
+	secretKey := make([]byte, 32)
+	_, err = rand.Read(secretKey)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
 	// Initialize a decoder instance...
 	formDecoder := form.NewDecoder()
 
+	// Limit the index the decoder accepts in field names like files[10].name,
+	// so that a crafted form can't make it allocate a huge slice.
+	formDecoder.SetMaxArraySize(maxSnippetFiles)
+
 	// Init a new instance of our application struct, containing the dependencies
 	// Init a models.SnippetModel instance containing the connection pool and add it to the application dependencies.
 	// And add it to the application dependencies.
+	analytics := &models.AnalyticsModel{DB: db}
+
 	app := &application{
-		logger:        logger,
-		snippets:      &models.SnippetModel{DB: db},
-		templateCache: templateCache,
-		formDecoder:   formDecoder,
+		logger:         logger,
+		snippets:       &models.SnippetModel{DB: db, Keys: keys},
+		templateCache:  templateCache,
+		formDecoder:    formDecoder,
+		legacyIDs:      cfg.legacyIDs,
+		secretKey:      secretKey,
+		visitorKey:     visitorKey,
+		unlockLimiter:  newUnlockLimiter(),
+		secretPolicy:   cfg.secretPolicy,
+		attachments:    &models.AttachmentModel{DB: db},
+		comments:       &models.CommentModel{DB: db},
+		stars:          &models.StarModel{DB: db},
+		feedTokens:     &models.FeedTokenModel{DB: db},
+		blobs:          blobs,
+		analytics:      analytics,
+		views:          newViewCounter(analytics, logger),
+		frameAncestors: frameAncestorsCSP,
+		collections:    &models.CollectionModel{DB: db},
+		metrics:        newMetrics(db),
+		db:             db,
+		logSample:      cfg.logSample,
+		slowRequest:    cfg.slowRequest,
+		logLevel:       cfg.logLevel,
+		trustedProxies: trustedProxies,
+		proxyHeader:    cfg.forwardedHeader,
+		compression: &compression{
+			encodings: compressEncodings,
+			minSize:   cfg.compressMinSize,
+			types:     parseCompressTypes(cfg.compressTypes),
+		},
+		rateLimiter: &rateLimiter{
+			store: rateStore,
+			limits: map[string]ratelimit.Limit{
+				ratePolicyRead:  cfg.rateRead,
+				ratePolicyWrite: cfg.rateWrite,
+				ratePolicyLogin: cfg.rateLogin,
+				ratePolicyAPI:   cfg.rateAPI,
+			},
+			byUser: cfg.rateKey == rateKeyUser,
+		},
 	}
 
-	logger.Info("starting server", "addr", *addr)
+	app.watchLogLevelSignal(cfg.logLevel.Level())
 
-	// Call the new app.routes() method to get the servemux containing our routes,
-	// and pass that to http.ListenAndServe().
+	// Call app.serve() to run the servers until the process is told to stop.
 	// Because the err variable is now already declared in the code above, we need
 	// to use the assignment operator = here, instead of the := 'declare and assign' operator.
-	err = http.ListenAndServe(*addr, app.routes())
-
-	// And we also use the Error() method to log any error message returned by
-	// http.ListenAndServe() at Error severity (with no additional attributes),
-	// and then call os.Exit(1) to terminate the application with exit code 1.
-	logger.Error(err.Error())
-	os.Exit(1)
-}
-
-// The openDB() function wraps sql.Open()
-// and returns a sql.DB connection pool for a given DSN.
-func openDB(dsn string) (*sql.DB, error) {
-	db, err := sql.Open("mysql", dsn)
-	if err != nil {
-		return nil, err
-	}
-
-	err = db.Ping()
+	err = app.serve(cfg.addr, cfg.adminAddr, cfg.drainDelay, cfg.shutdownTimeout)
 	if err != nil {
+		// Log any error at Error severity and terminate the application with exit code 1.
+		logger.Error(err.Error())
 		db.Close()
-		return nil, err
+		os.Exit(1)
 	}
+}
 
-	return db, nil
+// The openBlobStore() function returns the storage.BlobStore selected by the blob_store setting.
+func openBlobStore(kind, dir, endpoint, bucket, region string) (storage.BlobStore, error) {
+	switch kind {
+	case "local":
+		return storage.NewLocalStore(dir)
+	case "s3":
+		return storage.NewS3Store(endpoint, bucket, region, os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"))
+	default:
+		return nil, fmt.Errorf("invalid -blob-store value %q", kind)
+	}
 }
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metrics holds the Prometheus collectors of the application. They are registered with a registry
+// of their own (rather than the global default one), which is served on the admin listener.
+type metrics struct {
+	registry        *prometheus.Registry
+	requestDuration *prometheus.HistogramVec
+	snippetsCreated prometheus.Counter
+	snippetsViewed  *prometheus.CounterVec
+	rateLimited     *prometheus.CounterVec
+}
+
+func newMetrics(db *sql.DB) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "snippetbox_http_request_duration_seconds",
+			Help:    "Time taken to handle HTTP requests, by route pattern, method and status code.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method", "status"}),
+		snippetsCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "snippetbox_snippets_created_total",
+			Help: "Number of snippets created.",
+		}),
+		snippetsViewed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "snippetbox_snippets_viewed_total",
+			Help: "Number of snippet views, by kind of view (page or raw).",
+		}, []string{"kind"}),
+		rateLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "snippetbox_rate_limited_requests_total",
+			Help: "Number of requests rejected by a rate limit, by policy.",
+		}, []string{"policy"}),
+	}
+
+	m.registry.MustRegister(
+		m.requestDuration,
+		m.snippetsCreated,
+		m.snippetsViewed,
+		m.rateLimited,
+		collectors.NewDBStatsCollector(db, "snippetbox"),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	return m
+}
+
+// handler serves the metrics in the Prometheus text exposition format.
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// instrument records how long every request to mux takes. Requests are labelled with the pattern of the
+// route they matched in routes.go (like "GET /snippet/view/{slug}") rather than their path, so that the
+// number of label values stays small. Requests which match no route are all labelled "unmatched".
+func (app *application) instrument(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routePattern(mux, r)
+
+		start := time.Now()
+		rec := newResponseRecorder(w)
+
+		mux.ServeHTTP(rec, r)
+
+		// The method is chosen by the client, so unusual ones are grouped together for the same reason.
+		method := r.Method
+		switch method {
+		case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		default:
+			method = "OTHER"
+		}
+
+		app.metrics.requestDuration.
+			WithLabelValues(route, method, strconv.Itoa(rec.status)).
+			Observe(time.Since(start).Seconds())
+	})
+}
+
+// routePattern returns the pattern of the route in mux which matches the request,
+// or "unmatched" if there is none.
+func routePattern(mux *http.ServeMux, r *http.Request) string {
+	_, route := mux.Handler(r)
+	if route == "" {
+		return "unmatched"
+	}
+	return route
+}
@@ -1,15 +1,25 @@
 package main
 
 import (
+	"context"
+	crand "crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"log/slog"
+	"math/rand/v2"
 	"net/http"
+	"regexp"
+	"time"
 )
 
+// contentSecurityPolicy is the policy for every page, apart from the framing rules.
+// Pages that may be framed add their own frame-ancestors directive to it, see allowFraming.
+const contentSecurityPolicy = "default-src 'self'; style-src 'self' fonts.googleapis.com; font-src fonts.gstatic.com"
+
 // defines a middleware function that is used to apply security headers to HTTP responses.
 func commonHeaders(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Security-Policy",
-			"default-src 'self'; style-src 'self' fonts.googleapis.com; font-src fonts.gstatic.com")
+		w.Header().Set("Content-Security-Policy", contentSecurityPolicy+"; frame-ancestors 'none'")
 		w.Header().Set("Referrer-Policy", "origin-when-cross-origin")
 		w.Header().Set("X-Content-Type-Options", "nosniff")
 		w.Header().Set("X-Frame-Options", "deny")
@@ -21,18 +31,68 @@ func commonHeaders(next http.Handler) http.Handler {
 	})
 }
 
+// The header which carries the ID of a request, both in the request (if a proxy in front of us already
+// assigned one) and in the response.
+const requestIDHeader = "X-Request-ID"
+
+// requestIDRX matches the request IDs accepted from clients. Anything else is replaced with a new ID,
+// so that a client can't inject arbitrary text into the logs.
+var requestIDRX = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// requestID gives every request an ID, which is stored in the request context (where the logger picks it up),
+// echoed in the X-Request-ID response header and shown on error pages. An ID sent by the client is kept.
+func requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !requestIDRX.MatchString(id) {
+			b := make([]byte, 16)
+			crand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+
+		w.Header().Set(requestIDHeader, id)
+
+		ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// logRequest writes an access log entry for every request once its response has been sent,
+// with the status code, the size of the body and how long it took. Client errors (4xx) and slow requests
+// are logged at Warn level and server errors (5xx) at Error level. Of the remaining requests only
+// a sample is logged, as set by the -log-sample flag.
 func (app *application) logRequest(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var (
-			ip     = r.RemoteAddr
-			proto  = r.Proto
-			method = r.Method
-			uri    = r.URL.RequestURI()
-		)
+		start := time.Now()
+		rec := newResponseRecorder(w)
 
-		app.logger.Info("receive request", "ip", ip, "proto", proto, "method", method, "uri", uri)
+		next.ServeHTTP(rec, r)
 
-		next.ServeHTTP(w, r)
+		duration := time.Since(start)
+		slow := app.slowRequest > 0 && duration >= app.slowRequest
+
+		level := slog.LevelInfo
+		switch {
+		case rec.status >= 500:
+			level = slog.LevelError
+		case rec.status >= 400 || slow:
+			level = slog.LevelWarn
+		}
+
+		if level == slog.LevelInfo && app.logSample < 1 && rand.Float64() >= app.logSample {
+			return
+		}
+
+		app.logger.LogAttrs(r.Context(), level, "request",
+			slog.String("ip", clientIP(r)),
+			slog.String("proto", r.Proto),
+			slog.String("method", r.Method),
+			slog.String("uri", r.URL.RequestURI()),
+			slog.Int("status", rec.status),
+			slog.Int64("size", rec.size),
+			slog.Duration("duration", duration),
+			slog.Bool("slow", slow),
+		)
 	})
 }
 
@@ -52,3 +112,18 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// identify reads the owner cookie (if the visitor has one) and stores the hashed owner ID
+// in the request context, so that handlers can tell whether the visitor owns a snippet.
+func (app *application) identify(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie(ownerCookieName)
+		if err != nil || cookie.Value == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), ownerIDContextKey, hashOwnerToken(cookie.Value))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
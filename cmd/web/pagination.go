@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// pagination describes which page of a list is shown, for rendering "Previous" and "Next" links.
+type pagination struct {
+	Page  int
+	Pages int
+}
+
+func newPagination(page, total, perPage int) pagination {
+	pages := (total + perPage - 1) / perPage
+	if pages < 1 {
+		pages = 1
+	}
+
+	return pagination{Page: page, Pages: pages}
+}
+
+func (p pagination) HasPrevious() bool {
+	return p.Page > 1
+}
+
+func (p pagination) HasNext() bool {
+	return p.Page < p.Pages
+}
+
+func (p pagination) Previous() int {
+	return p.Page - 1
+}
+
+func (p pagination) Next() int {
+	return p.Page + 1
+}
+
+// pageNumber reads the page query string parameter, falling back to the first page if it's missing or invalid.
+func pageNumber(r *http.Request) int {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		return 1
+	}
+
+	return page
+}
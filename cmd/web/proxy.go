@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// The values accepted by the forwarded_header setting: the header in which the trusted proxies pass on the client.
+const (
+	forwardedHeaderXFF       = "x-forwarded-for"
+	forwardedHeaderForwarded = "forwarded"
+)
+
+// client is what the resolveClient middleware found out about the client behind the proxies.
+type client struct {
+	ip     string
+	scheme string
+}
+
+// parseTrustedProxies parses the trusted_proxies setting: a comma-separated list of CIDR ranges,
+// like "10.0.0.0/8, fd00::/8". A single address stands for a range of just that address.
+func parseTrustedProxies(value string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+
+	for _, s := range strings.Split(value, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted_proxies entry %q", s)
+			}
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted_proxies entry %q", s)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	return prefixes, nil
+}
+
+// isTrustedProxy reports whether addr belongs to one of the trusted proxies.
+func (app *application) isTrustedProxy(addr netip.Addr) bool {
+	addr = addr.Unmap()
+
+	for _, prefix := range app.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hop is one step of the way from the client to the application, as told by a proxy:
+// the address the proxy received the request from, and the scheme it was received with.
+type hop struct {
+	addr   netip.Addr
+	scheme string
+}
+
+// resolveClient finds the IP address and the scheme the client used, when the application runs behind
+// load balancers or other reverse proxies. Those are listed in the trusted_proxies setting, and only
+// their headers are believed: anyone else can send an X-Forwarded-For header with any address in it.
+//
+// The proxies maintain one header, named by the forwarded_header setting: either the RFC 7239 Forwarded header,
+// or X-Forwarded-For together with X-Forwarded-Proto. Only that one is read, whatever else the request
+// carries, since the proxies pass the other one on from the client untouched. Its hops are read from
+// right to left, starting from the proxy which connected to the application, for as long as they are
+// trusted proxies. The first hop which isn't one is the client.
+func (app *application) resolveClient(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := client{ip: remoteIP(r), scheme: "http"}
+		if r.TLS != nil {
+			c.scheme = "https"
+		}
+
+		peer, err := netip.ParseAddr(c.ip)
+		if err == nil && len(app.trustedProxies) > 0 && app.isTrustedProxy(peer) {
+			hops := forwardedHops(r, app.proxyHeader)
+
+			for i := len(hops) - 1; i >= 0; i-- {
+				// An address that can't be parsed (like "unknown" or an obfuscated identifier) ends the search,
+				// since we can't tell whether it's a proxy. The hop to its right is the best we know.
+				if !hops[i].addr.IsValid() {
+					break
+				}
+
+				c.ip = hops[i].addr.Unmap().String()
+				if hops[i].scheme != "" {
+					c.scheme = hops[i].scheme
+				}
+
+				if !app.isTrustedProxy(hops[i].addr) {
+					break
+				}
+			}
+		}
+
+		ctx := context.WithValue(r.Context(), clientContextKey, c)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// forwardedHops returns the hops listed in the given header: the RFC 7239 Forwarded header, or the
+// X-Forwarded-For and X-Forwarded-Proto headers. The client comes first.
+func forwardedHops(r *http.Request, header string) []hop {
+	if header == forwardedHeaderForwarded {
+		values := r.Header.Values("Forwarded")
+		if len(values) == 0 {
+			return nil
+		}
+		return parseForwarded(strings.Join(values, ","))
+	}
+
+	var hops []hop
+
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		for _, s := range strings.Split(value, ",") {
+			hops = append(hops, hop{addr: parseNodeAddr(s)})
+		}
+	}
+
+	// Some proxies append to X-Forwarded-Proto as they do to X-Forwarded-For, and then the schemes belong to
+	// the hops in the same position. Usually though the proxy at the edge sets it and the ones after it pass it
+	// on, and then its last value is the scheme of the client, whichever hop that turns out to be.
+	var schemes []string
+	for _, value := range r.Header.Values("X-Forwarded-Proto") {
+		for _, s := range strings.Split(value, ",") {
+			schemes = append(schemes, parseScheme(s))
+		}
+	}
+
+	switch {
+	case len(schemes) == len(hops):
+		for i := range hops {
+			hops[i].scheme = schemes[i]
+		}
+	case len(schemes) > 0:
+		for i := range hops {
+			hops[i].scheme = schemes[len(schemes)-1]
+		}
+	}
+
+	return hops
+}
+
+// parseForwarded parses the elements of a Forwarded header, like:
+//
+//	Forwarded: for=192.0.2.60;proto=https, for="[2001:db8:cafe::17]:4711"
+//
+// Elements without a for parameter are hops with an unknown address.
+func parseForwarded(value string) []hop {
+	var hops []hop
+
+	for _, element := range strings.Split(value, ",") {
+		var h hop
+
+		for _, pair := range strings.Split(element, ";") {
+			key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok {
+				continue
+			}
+
+			switch strings.ToLower(key) {
+			case "for":
+				h.addr = parseNodeAddr(val)
+			case "proto":
+				h.scheme = parseScheme(val)
+			}
+		}
+
+		hops = append(hops, h)
+	}
+
+	return hops
+}
+
+// parseNodeAddr parses the address of a node, which may be quoted and have a port, as in "[2001:db8::1]:4711".
+// It returns the zero netip.Addr for anything else, like "unknown".
+func parseNodeAddr(s string) netip.Addr {
+	s = strings.Trim(strings.TrimSpace(s), `"`)
+
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr
+}
+
+// parseScheme returns the scheme if it's http or https, and the empty string for anything else.
+func parseScheme(s string) string {
+	s = strings.ToLower(strings.Trim(strings.TrimSpace(s), `"`))
+	if s == "http" || s == "https" {
+		return s
+	}
+	return ""
+}
+
+// remoteIP returns the IP address of the peer which connected to the application.
+func remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// clientIP returns the IP address of the client which made the request, as found by resolveClient.
+func clientIP(r *http.Request) string {
+	if c, ok := r.Context().Value(clientContextKey).(client); ok {
+		return c.ip
+	}
+	return remoteIP(r)
+}
+
+// clientScheme returns the scheme ("http" or "https") the client used to make the request, as found by resolveClient.
+func clientScheme(r *http.Request) string {
+	if c, ok := r.Context().Value(clientContextKey).(client); ok {
+		return c.scheme
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"snippetbox.t10i.net/internal/ratelimit"
+)
+
+// The rate limit policies. Every request falls under one of them, unless it's exempt.
+const (
+	ratePolicyRead  = "read"
+	ratePolicyWrite = "write"
+	ratePolicyLogin = "login"
+	ratePolicyAPI   = "api"
+)
+
+// The values accepted by the rate_key setting.
+const (
+	rateKeyIP   = "ip"
+	rateKeyUser = "user"
+)
+
+// The values accepted by the rate_store setting.
+const (
+	rateStoreMemory = "memory"
+	rateStoreMySQL  = "mysql"
+)
+
+// rateLimiter holds the limits of the policies, and the store with the token buckets.
+type rateLimiter struct {
+	store  ratelimit.Store
+	limits map[string]ratelimit.Limit
+	byUser bool
+}
+
+// limitFlag is a flag.Value for the rate_* settings, in the form accepted by ratelimit.ParseLimit().
+type limitFlag struct {
+	limit *ratelimit.Limit
+}
+
+func (f limitFlag) String() string {
+	// The flag package calls String() on a zero limitFlag to find out whether a default is set.
+	if f.limit == nil {
+		return ""
+	}
+	return f.limit.String()
+}
+
+func (f limitFlag) Set(s string) error {
+	limit, err := ratelimit.ParseLimit(s)
+	if err != nil {
+		return err
+	}
+
+	*f.limit = limit
+	return nil
+}
+
+// ratePolicy returns the rate limit policy of a request: attempts at a snippet password count as logins,
+// the JSON API has its own policy, and the rest are reads or writes depending on the method. Static files
+// and the health checks are exempt (the empty policy), as the probes all come from the load balancer.
+func ratePolicy(r *http.Request) string {
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/static/"), r.URL.Path == "/healthz", r.URL.Path == "/readyz":
+		return ""
+	case strings.HasPrefix(r.URL.Path, "/api/"):
+		return ratePolicyAPI
+	case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/snippet/unlock/"):
+		return ratePolicyLogin
+	case r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions:
+		return ratePolicyRead
+	default:
+		return ratePolicyWrite
+	}
+}
+
+// rateLimitKeys returns the keys of the buckets of the policy that the request is counted against. Every request
+// is counted against its client IP. With the rate_key setting "user", requests which carry an owner ID are counted
+// against it as well, as an extra limit for one visitor sharing their IP with others: anyone can get a fresh owner ID by
+// sending a new cookie, so an owner ID alone would let them dodge the limit.
+func (app *application) rateLimitKeys(r *http.Request, policy string) []string {
+	keys := []string{policy + ":ip:" + clientIP(r)}
+
+	if app.rateLimiter.byUser {
+		if ownerID := app.ownerID(r); ownerID != "" {
+			keys = append(keys, policy+":user:"+ownerID)
+		}
+	}
+
+	return keys
+}
+
+// rateLimit rejects requests with 429 Too Many Requests once the client has used up the token bucket of the
+// request's policy, telling them in the Retry-After header when they may try again. It comes after the identify
+// middleware, so that it can also count requests by owner ID.
+//
+// If the store of the buckets fails, the request is let through: an outage of the shared store shouldn't take
+// the whole site down with it.
+func (app *application) rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		policy := ratePolicy(r)
+
+		limit := app.rateLimiter.limits[policy]
+		if policy == "" || limit.Unlimited() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// The request must fit in every one of its buckets. If one of them refuses it, nothing is taken
+		// from the others, so that one visitor using up their own limit doesn't drain the bucket of their IP.
+		allowed, retryAfter, err := app.rateLimiter.store.TakeAll(r.Context(), app.rateLimitKeys(r, policy), limit)
+		if err != nil {
+			app.logger.WarnContext(r.Context(), "rate limit store failed", "error", err.Error())
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !allowed {
+			app.metrics.rateLimited.WithLabelValues(policy).Inc()
+
+			w.Header().Set("Retry-After", strconv.Itoa(max(1, int(math.Ceil(retryAfter.Seconds())))))
+
+			if policy == ratePolicyAPI {
+				app.errorJSON(w, r, http.StatusTooManyRequests, "rate limit exceeded, try again later")
+				return
+			}
+
+			app.clientError(w, http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+)
+
+// responseRecorder wraps a http.ResponseWriter to remember the status code and the number of bytes
+// of the response, for the middleware which report on responses after they have been sent.
+// It passes Flush() and Hijack() calls on to the wrapped http.ResponseWriter, so that streaming
+// responses and protocol upgrades keep working behind it.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	size        int64
+	wroteHeader bool
+}
+
+func newResponseRecorder(w http.ResponseWriter) *responseRecorder {
+	return &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	// Informational (1xx) responses are followed by the real one.
+	if !rr.wroteHeader && status >= 200 {
+		rr.status = status
+		rr.wroteHeader = true
+	}
+
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	rr.wroteHeader = true
+
+	n, err := rr.ResponseWriter.Write(b)
+	rr.size += int64(n)
+
+	return n, err
+}
+
+// Unwrap returns the original http.ResponseWriter, so that http.ResponseController
+// can still reach its optional methods through the recorder.
+func (rr *responseRecorder) Unwrap() http.ResponseWriter {
+	return rr.ResponseWriter
+}
+
+// Flush sends any buffered data to the client, if the wrapped http.ResponseWriter supports it.
+func (rr *responseRecorder) Flush() {
+	if f, ok := rr.ResponseWriter.(http.Flusher); ok {
+		rr.wroteHeader = true
+		f.Flush()
+	}
+}
+
+// Hijack lets the caller take over the connection, if the wrapped http.ResponseWriter supports it.
+// A hijacked connection is recorded with the status 101 Switching Protocols, since whatever is sent
+// over it afterwards is out of sight.
+func (rr *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := rr.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+
+	conn, rw, err := hj.Hijack()
+	if err == nil && !rr.wroteHeader {
+		rr.status = http.StatusSwitchingProtocols
+		rr.wroteHeader = true
+	}
+
+	return conn, rw, err
+}
@@ -24,16 +24,76 @@ func (app *application) routes() http.Handler {
 	// For matching paths, we strip the "/static" prefix before the request reaches the file server.
 	mux.Handle("GET /static/", http.StripPrefix("/static", fileServer))
 
+	// Health checks for the orchestrator.
+	mux.HandleFunc("GET /healthz", app.healthz)
+	mux.HandleFunc("GET /readyz", app.readyz)
+
 	// Swap the route declarations to use the application struct's methods as the handler functions.
 	mux.HandleFunc("GET /{$}", app.home)
-	mux.HandleFunc("GET /snippet/view/{id}", app.snippetView)
+	mux.HandleFunc("GET /snippet/view/{slug}", app.snippetView)
+	mux.HandleFunc("POST /snippet/unlock/{slug}", app.snippetUnlockPost)
+	mux.HandleFunc("GET /snippet/raw/{slug}", app.snippetRaw)
+	mux.Handle("GET /snippet/embed/{slug}", app.allowFraming(http.HandlerFunc(app.snippetEmbed)))
+	mux.HandleFunc("GET /oembed", app.oEmbed)
+	mux.HandleFunc("GET /snippet/download/{slug}", app.snippetDownload)
+	mux.HandleFunc("GET /snippet/analytics/{slug}", app.snippetAnalytics)
+	mux.HandleFunc("GET /snippet/attachment/{slug}/{id}", app.snippetAttachment)
+	mux.HandleFunc("POST /snippet/comment/{slug}", app.snippetCommentPost)
+	mux.HandleFunc("POST /snippet/star/{slug}", app.snippetStarPost)
+	mux.HandleFunc("POST /snippet/unstar/{slug}", app.snippetUnstarPost)
+	mux.HandleFunc("POST /snippet/collect/{slug}", app.snippetCollectPost)
+	mux.HandleFunc("GET /snippet/fork/{slug}", app.snippetFork)
 	mux.HandleFunc("GET /snippet/create", app.snippetCreate)
 	mux.HandleFunc("POST /snippet/create", app.snippetCreatePost)
+	mux.HandleFunc("GET /user/starred", app.userStarred)
+	mux.HandleFunc("POST /user/feed-token", app.userFeedTokenPost)
+	mux.HandleFunc("POST /user/feed-token/delete", app.userFeedTokenDeletePost)
+	mux.HandleFunc("GET /collection/create", app.collectionCreate)
+	mux.HandleFunc("POST /collection/create", app.collectionCreatePost)
+	mux.HandleFunc("GET /collection/view/{slug}", app.collectionView)
+	mux.HandleFunc("POST /collection/update/{slug}", app.collectionUpdatePost)
+	mux.HandleFunc("POST /collection/delete/{slug}", app.collectionDeletePost)
+	mux.HandleFunc("POST /collection/remove/{slug}", app.collectionRemovePost)
+	mux.HandleFunc("POST /collection/move/{slug}", app.collectionMovePost)
+	mux.HandleFunc("GET /user/collections", app.userCollections)
+	mux.HandleFunc("GET /feed.atom", app.feedLatest(feedAtom))
+	mux.HandleFunc("GET /feed.rss", app.feedLatest(feedRSS))
+	mux.HandleFunc("GET /feed/language/{name}", app.feedLanguage)
+	mux.HandleFunc("GET /feed/user/{name}", app.feedUser)
+
+	// The JSON API. It identifies visitors by the same owner cookie as the HTML pages.
+	mux.HandleFunc("GET /api/collections", app.apiCollections)
+	mux.HandleFunc("POST /api/collections", app.apiCollectionCreate)
+	mux.HandleFunc("GET /api/collections/{slug}", app.apiCollection)
+	mux.HandleFunc("PATCH /api/collections/{slug}", app.apiCollectionUpdate)
+	mux.HandleFunc("DELETE /api/collections/{slug}", app.apiCollectionDelete)
+	mux.HandleFunc("POST /api/collections/{slug}/snippets", app.apiCollectionAdd)
+	mux.HandleFunc("DELETE /api/collections/{slug}/snippets/{snippet}", app.apiCollectionRemove)
+	mux.HandleFunc("PUT /api/collections/{slug}/order", app.apiCollectionOrder)
 
 	// Create a middleware chain containing our 'standard' middleware
 	// which will be used for every request our application receives.
-	standard := alice.New(app.recoverPanic, app.logRequest, commonHeaders)
+	// The request ID comes first, so that everything after it (panics included) is logged with the ID.
+	// The client behind the proxies is resolved before anything uses its IP address.
+	// The request span is started next, so that the access log can include the trace ID.
+	// The access log wraps recoverPanic, so that it also records the 500 responses sent after a panic.
+	// Responses are compressed below the access log, which therefore records the size actually sent.
+	// The rate limits come last, as they can count requests by the owner ID which identify looks up.
+	standard := alice.New(requestID, app.resolveClient, app.traceRequests(mux), app.logRequest, app.compress, app.recoverPanic, commonHeaders, app.identify, app.rateLimit)
+
+	// Return the 'standard' middleware chain followed by the servemux, which is instrumented
+	// on the inside so that the metrics can be labelled with the route each request matched.
+	return standard.Then(app.instrument(mux))
+}
+
+// adminRoutes returns the handler of the admin listener, with the metrics and the log level. It's kept apart from the public routes,
+// so that it can be bound to an address which only the operators (and their Prometheus) can reach.
+func (app *application) adminRoutes() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.Handle("GET /metrics", app.metrics.handler())
+	mux.HandleFunc("GET /log-level", app.logLevelHandler)
+	mux.HandleFunc("PUT /log-level", app.logLevelHandler)
 
-	// Return the 'standard' middleware chain followed by the servemux.
-	return standard.Then(mux)
+	return mux
 }
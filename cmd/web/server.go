@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// serve runs the HTTP server (and the admin server, if adminAddr isn't empty) until the process receives
+// SIGINT or SIGTERM. It then shuts down gracefully: first /readyz starts reporting 503 for drainDelay,
+// so that load balancers stop sending new requests, and then the servers stop accepting connections and
+// wait up to shutdownTimeout for the requests in flight to finish.
+func (app *application) serve(addr, adminAddr string, drainDelay, shutdownTimeout time.Duration) error {
+	srv := &http.Server{
+		Addr:     addr,
+		Handler:  app.routes(),
+		ErrorLog: slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
+	}
+
+	var adminSrv *http.Server
+	if adminAddr != "" {
+		adminSrv = &http.Server{
+			Addr:     adminAddr,
+			Handler:  app.adminRoutes(),
+			ErrorLog: slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
+		}
+	}
+
+	shutdownError := make(chan error)
+
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		s := <-quit
+
+		app.logger.Info("draining server", "signal", s.String(), "delay", drainDelay.String())
+		app.draining.Store(true)
+		time.Sleep(drainDelay)
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		app.logger.Info("shutting down server")
+
+		if adminSrv != nil {
+			adminSrv.Shutdown(ctx)
+		}
+
+		shutdownError <- srv.Shutdown(ctx)
+	}()
+
+	// The admin server is started in the background. If it fails, the application keeps serving its users.
+	if adminSrv != nil {
+		go func() {
+			app.logger.Info("starting admin server", "addr", adminAddr)
+
+			err := adminSrv.ListenAndServe()
+			if !errors.Is(err, http.ErrServerClosed) {
+				app.logger.Error("admin server stopped", "error", err.Error())
+			}
+		}()
+	}
+
+	app.logger.Info("starting server", "addr", addr)
+
+	// ListenAndServe() returns http.ErrServerClosed as soon as Shutdown() is called,
+	// which only means that the graceful shutdown has started.
+	err := srv.ListenAndServe()
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	err = <-shutdownError
+	if err != nil {
+		return err
+	}
+
+	// Write the view counts that haven't been saved yet, now that no more requests can come in.
+	app.views.Close()
+
+	app.logger.Info("stopped server")
+
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "log/slog"
+
+// watchLogLevelSignal does nothing on systems without SIGUSR1.
+// The log level can still be changed through the admin endpoint.
+func (app *application) watchLogLevelSignal(configured slog.Level) {}
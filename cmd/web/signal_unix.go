@@ -0,0 +1,28 @@
+//go:build unix
+
+package main
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// watchLogLevelSignal switches the log level to Debug when the process receives SIGUSR1,
+// and back to the configured level when it receives SIGUSR1 again.
+func (app *application) watchLogLevelSignal(configured slog.Level) {
+	usr1 := make(chan os.Signal, 1)
+	signal.Notify(usr1, syscall.SIGUSR1)
+
+	go func() {
+		for range usr1 {
+			level := slog.LevelDebug
+			if app.logLevel.Level() == slog.LevelDebug {
+				level = configured
+			}
+
+			app.setLogLevel(level, "SIGUSR1")
+		}
+	}()
+}
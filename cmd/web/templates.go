@@ -1,10 +1,13 @@
 package main
 
 import (
+	"fmt"
 	"html/template"
 	"path/filepath"
+	"strings"
 	"time"
 
+	"snippetbox.t10i.net/internal/markdown"
 	"snippetbox.t10i.net/internal/models"
 )
 
@@ -13,7 +16,21 @@ type templateData struct {
 	CurrentYear int
 	Snippet     models.Snippet
 	Snippets    []models.Snippet
+	Collection  models.Collection
+	Collections []models.Collection
+	Attachments []models.Attachment
+	Original    models.Snippet
+	Forks       []models.Snippet
+	Comments    []*models.Comment
+	Pagination  pagination
+	Starred     bool
+	IsOwner     bool
+	FeedToken   string
+	Daily       []models.DailyStats
+	Referrers   []models.ReferrerCount
 	Form        any
+	Languages   map[string]string
+	BaseURL     string
 }
 
 // Create a humanDate function which returns a nicely formatted string representation of a time.Time object.
@@ -21,11 +38,45 @@ func humanDate(t time.Time) string {
 	return t.Format("02 Jan 2006 at 15:04")
 }
 
+// humanBytes returns a size in bytes in the largest unit that keeps it above 1, like "1.5 MB".
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGT"[exp])
+}
+
+// lines splits content into its lines, without the line endings.
+// A trailing line ending doesn't start another (empty) line.
+func lines(content string) []string {
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	content = strings.TrimSuffix(content, "\n")
+
+	return strings.Split(content, "\n")
+}
+
+// inc returns n+1, which turns zero-based range indexes into line and page numbers.
+func inc(n int) int {
+	return n + 1
+}
+
 // Initialize a template.FuncMap object and store it in a global variable.
 // This is essentially a string-keyed map which acts as a lookup between the names of our
 // custom template functions and the functions them selves.
 var functions = template.FuncMap{
-	"humanDate": humanDate,
+	"humanDate":  humanDate,
+	"humanBytes": humanBytes,
+	"lines":      lines,
+	"inc":        inc,
+	"markdown":   markdown.Render,
 }
 
 func newTemplateCache() (map[string]*template.Template, error) {
@@ -50,7 +101,7 @@ func newTemplateCache() (map[string]*template.Template, error) {
 		// The template.FuncMap must be registered with the template set before you call the ParseFiles() method.
 		// This means we have to use template.New() to create an empty template set,
 		// use the Funcs() method to register the template.FuncMap, and then parse the file as normal.
-		ts, err := template.New(name).Funcs(functions).ParseFiles("./ui/html/base.tmpl")
+		ts, err := template.New(name).Funcs(functions).ParseFiles("./ui/html/base.tmpl", "./ui/html/embed.tmpl")
 		if err != nil {
 			return nil, err
 		}
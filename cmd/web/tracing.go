@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The values accepted by the trace_exporter setting.
+const (
+	traceExporterNone   = "none"
+	traceExporterOTLP   = "otlp"
+	traceExporterStdout = "stdout"
+)
+
+// How long the shutdown waits for the last spans to be exported.
+const traceShutdownTimeout = 5 * time.Second
+
+// tracer creates the spans of the web application: one for every request, and one for every page rendered.
+// The spans of the database queries are created in internal/models.
+var tracer = otel.Tracer("snippetbox.t10i.net/cmd/web")
+
+// setupTracing installs the global tracer provider, which sends the spans to the exporter chosen by the
+// trace_exporter setting: an OTLP/HTTP collector (at endpoint, or at the one from the standard
+// OTEL_EXPORTER_OTLP_* environment variables if it's empty) or standard out, for local debugging.
+// A fraction of the traces is sampled, unless the caller's traceparent header says it sampled the trace.
+//
+// The returned function flushes the spans which haven't been exported yet, and must be called on exit.
+func setupTracing(exporter, endpoint string, sample float64) (func(), error) {
+	// The W3C traceparent header is honoured even without an exporter, so that the trace ID of the caller
+	// still shows up in the log.
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var (
+		spanExporter sdktrace.SpanExporter
+		err          error
+	)
+
+	switch exporter {
+	case traceExporterNone:
+		return func() {}, nil
+	case traceExporterOTLP:
+		var opts []otlptracehttp.Option
+		if endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(endpoint))
+		}
+		spanExporter, err = otlptracehttp.New(context.Background(), opts...)
+	case traceExporterStdout:
+		spanExporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout), stdouttrace.WithPrettyPrint())
+	default:
+		return nil, fmt.Errorf("invalid trace_exporter value %q", exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(attribute.String("service.name", "snippetbox")))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sample))),
+	)
+
+	otel.SetTracerProvider(provider)
+
+	shutdown := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), traceShutdownTimeout)
+		defer cancel()
+
+		provider.Shutdown(ctx)
+	}
+
+	return shutdown, nil
+}
+
+// traceRequests starts a server span for every request, as a child of the span in the traceparent header
+// if there is one. Like the metrics, the span is named after the route pattern that the request matches in mux,
+// so that all the requests for one page are grouped together. It comes early in the middleware chain,
+// so that the access log and everything after it can see the trace.
+func (app *application) traceRequests(mux *http.ServeMux) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+			route := routePattern(mux, r)
+
+			// Patterns without a method, like "/static/", get one, as span names are "{method} {route}".
+			name := route
+			if !strings.Contains(route, " ") {
+				name = r.Method + " " + route
+			}
+
+			ctx, span := tracer.Start(ctx, name,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					attribute.String("http.request.method", r.Method),
+					attribute.String("http.route", route),
+					attribute.String("url.path", r.URL.Path),
+					attribute.String("url.scheme", clientScheme(r)),
+					attribute.String("client.address", clientIP(r)),
+					attribute.String("user_agent.original", r.UserAgent()),
+				))
+			defer span.End()
+
+			if id, ok := ctx.Value(requestIDContextKey).(string); ok {
+				span.SetAttributes(attribute.String("request.id", id))
+			}
+
+			rec := newResponseRecorder(w)
+
+			next.ServeHTTP(rec, r.WithContext(ctx))
+
+			span.SetAttributes(attribute.Int("http.response.status_code", rec.status))
+
+			// Client errors are the client's problem, so only server errors mark the span as failed.
+			if rec.status >= 500 {
+				span.SetStatus(codes.Error, http.StatusText(rec.status))
+			}
+		})
+	}
+}
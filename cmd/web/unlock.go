@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+
+	"snippetbox.t10i.net/internal/models"
+)
+
+// How many unlock attempts a visitor may make for a protected snippet within unlockWindow before
+// further attempts are rejected with 429 Too Many Requests. A correct password starts the count again.
+const (
+	maxUnlockAttempts = 5
+	unlockWindow      = 15 * time.Minute
+)
+
+// unlockLimiter counts unlock attempts per snippet and client IP. Counting by IP as well means that
+// someone guessing at a snippet can't lock everyone else (its owner included) out of it.
+// The counts live in memory, so they are reset when the application restarts.
+type unlockLimiter struct {
+	mu       sync.Mutex
+	attempts map[string]*unlockAttempts
+}
+
+type unlockAttempts struct {
+	count int
+	start time.Time
+}
+
+func newUnlockLimiter() *unlockLimiter {
+	return &unlockLimiter{attempts: make(map[string]*unlockAttempts)}
+}
+
+// unlockLimitKey returns the key under which the attempts at a snippet from one client are counted.
+func unlockLimitKey(slug, ip string) string {
+	return slug + " " + ip
+}
+
+// Attempt records an unlock attempt under key, and reports whether it may go ahead.
+// Checking and recording happen under one lock, so that concurrent guesses can't all slip through
+// before the first of them is counted. When it returns false, the second value says how long the caller has to wait.
+func (l *unlockLimiter) Attempt(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Drop the windows that have run out while we hold the lock anyway,
+	// so that the map doesn't keep growing with clients nobody hears from any more.
+	now := time.Now()
+	for k, a := range l.attempts {
+		if now.Sub(a.start) >= unlockWindow {
+			delete(l.attempts, k)
+		}
+	}
+
+	a, ok := l.attempts[key]
+	if !ok {
+		a = &unlockAttempts{start: now}
+		l.attempts[key] = a
+	}
+
+	if a.count >= maxUnlockAttempts {
+		return false, unlockWindow - now.Sub(a.start)
+	}
+
+	a.count++
+	return true, 0
+}
+
+// Reset forgets the attempts under key, after the right password was entered.
+func (l *unlockLimiter) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.attempts, key)
+}
+
+// The cookies that remember an unlocked snippet are named after the snippet,
+// so that every snippet is unlocked separately.
+func unlockCookieName(slug string) string {
+	return "unlock_" + slug
+}
+
+// unlockToken returns the value of the unlock cookie for a snippet. It's an HMAC of the slug and the
+// password hash, signed with the application's secret key, so it can't be forged for another snippet
+// and stops working if the snippet's password is ever changed.
+func (app *application) unlockToken(snippet models.Snippet) string {
+	mac := hmac.New(sha256.New, app.secretKey)
+	mac.Write([]byte(snippet.Slug))
+	mac.Write(snippet.HashedPassword)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// isUnlocked reports whether the current visitor may see the content of the snippet,
+// either because it isn't protected, because they own it, or because they entered the password earlier in this session.
+func (app *application) isUnlocked(r *http.Request, snippet models.Snippet) bool {
+	if !snippet.Protected() {
+		return true
+	}
+
+	if app.isOwner(r, snippet) {
+		return true
+	}
+
+	cookie, err := r.Cookie(unlockCookieName(snippet.Slug))
+	if err != nil {
+		return false
+	}
+
+	return hmac.Equal([]byte(cookie.Value), []byte(app.unlockToken(snippet)))
+}
+
+// unlock grants the current visitor access to the snippet until the end of their browser session.
+// The cookie deliberately has no Expires or Max-Age attribute.
+func (app *application) unlock(w http.ResponseWriter, snippet models.Snippet) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     unlockCookieName(snippet.Slug),
+		Value:    app.unlockToken(snippet),
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
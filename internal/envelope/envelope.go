@@ -0,0 +1,223 @@
+// Package envelope implements envelope encryption with AES-GCM.
+//
+// Every value is encrypted with its own random data key. The data key is then encrypted ("wrapped")
+// with a master key, and stored next to the ciphertext together with the ID of that master key.
+// Rotating the master key therefore only means re-wrapping the small data keys, not re-encrypting the data.
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// KeySize is the size in bytes of both master keys and data keys (AES-256).
+const KeySize = 32
+
+var (
+	ErrUnknownKey = errors.New("envelope: data key was wrapped with an unknown master key")
+	ErrNoKey      = errors.New("envelope: no master key configured")
+)
+
+// Keyring holds the master keys. New data keys are always wrapped with the primary key,
+// while the other keys can still unwrap data keys that were wrapped before a rotation.
+type Keyring struct {
+	primary string
+	keys    map[string]cipher.AEAD
+}
+
+// NewKeyring returns a Keyring with primary as the key for wrapping new data keys.
+// The previous keys are only used for unwrapping.
+func NewKeyring(primary []byte, previous ...[]byte) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string]cipher.AEAD)}
+
+	for i, key := range append([][]byte{primary}, previous...) {
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+
+		id := KeyID(key)
+		if i == 0 {
+			k.primary = id
+		}
+		k.keys[id] = aead
+	}
+
+	return k, nil
+}
+
+// KeyID returns the identifier stored alongside data keys wrapped with the given master key.
+// It's a truncated SHA-256 hash, so it doesn't reveal anything about the key itself.
+func KeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// PrimaryID returns the ID of the master key that new data keys are wrapped with.
+func (k *Keyring) PrimaryID() string {
+	return k.primary
+}
+
+// Seal encrypts plaintext with a new data key, and returns the ciphertext along with the wrapped
+// data key and the ID of the master key that wrapped it.
+func (k *Keyring) Seal(plaintext []byte) (ciphertext, wrappedKey []byte, keyID string, err error) {
+	dataKey := make([]byte, KeySize)
+
+	_, err = rand.Read(dataKey)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	ciphertext, err = seal(aead, plaintext)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	wrappedKey, err = seal(k.keys[k.primary], dataKey)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	return ciphertext, wrappedKey, k.primary, nil
+}
+
+// Open unwraps the data key with the master key identified by keyID and uses it to decrypt ciphertext.
+func (k *Keyring) Open(ciphertext, wrappedKey []byte, keyID string) ([]byte, error) {
+	dataKey, err := k.unwrap(wrappedKey, keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return open(aead, ciphertext)
+}
+
+// Rewrap unwraps a data key and wraps it again with the primary master key.
+func (k *Keyring) Rewrap(wrappedKey []byte, keyID string) ([]byte, string, error) {
+	dataKey, err := k.unwrap(wrappedKey, keyID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	wrappedKey, err = seal(k.keys[k.primary], dataKey)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return wrappedKey, k.primary, nil
+}
+
+func (k *Keyring) unwrap(wrappedKey []byte, keyID string) ([]byte, error) {
+	aead, ok := k.keys[keyID]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	return open(aead, wrappedKey)
+}
+
+// The environment variables that master keys are read from when no key file is given.
+const (
+	MasterKeyEnv         = "SNIPPETBOX_MASTER_KEY"
+	PreviousMasterKeyEnv = "SNIPPETBOX_PREVIOUS_MASTER_KEY"
+)
+
+// LoadKeyring loads the primary master key from path (or MasterKeyEnv) and, if one is configured,
+// the previous master key from previousPath (or PreviousMasterKeyEnv). It returns ErrNoKey if
+// there is no primary key.
+func LoadKeyring(path, previousPath string) (*Keyring, error) {
+	primary, err := LoadKey(path, MasterKeyEnv)
+	if err != nil {
+		return nil, err
+	}
+
+	var previous [][]byte
+
+	key, err := LoadKey(previousPath, PreviousMasterKeyEnv)
+	switch {
+	case err == nil:
+		previous = append(previous, key)
+	case !errors.Is(err, ErrNoKey):
+		return nil, err
+	}
+
+	return NewKeyring(primary, previous...)
+}
+
+// LoadKey reads a base64-encoded master key from the file at path or, if path is empty,
+// from the environment variable env. It returns ErrNoKey if neither is set.
+func LoadKey(path, env string) ([]byte, error) {
+	var encoded string
+
+	switch {
+	case path != "":
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		encoded = string(b)
+	case os.Getenv(env) != "":
+		encoded = os.Getenv(env)
+	default:
+		return nil, ErrNoKey
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("envelope: master key is not valid base64: %w", err)
+	}
+
+	return key, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("envelope: key must be %d bytes long, got %d", KeySize, len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plaintext with a random nonce, which is prepended to the returned ciphertext.
+func seal(aead cipher.AEAD, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(aead cipher.AEAD, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("envelope: ciphertext is too short")
+	}
+
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
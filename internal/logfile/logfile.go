@@ -0,0 +1,141 @@
+// Package logfile provides a log file which rotates itself when it grows too large.
+package logfile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// File is an io.Writer which appends to a file at Path. When a write would make the file larger than
+// MaxSize bytes, the file is renamed to Path.1 first (after Path.1 is renamed to Path.2, and so on),
+// and a new file is started. At most MaxBackups of the old files are kept.
+//
+// A File is safe for concurrent use.
+type File struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu        sync.Mutex
+	file      *os.File
+	size      int64
+	lastRetry time.Time
+}
+
+// How often a File which fell back to standard error tries to open its file again.
+const reopenInterval = time.Second
+
+// Open opens (or creates) the log file at path for appending.
+// A maxSize of 0 or less disables rotation.
+func Open(path string, maxSize int64, maxBackups int) (*File, error) {
+	f := &File{path: path, maxSize: maxSize, maxBackups: maxBackups}
+
+	err := f.open()
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (f *File) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+
+	return nil
+}
+
+// Write appends p to the file, rotating it first if p wouldn't fit.
+// A single write is never split across two files.
+func (f *File) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// After a failed rotation the log goes to standard error, until the file can be opened again.
+	if f.file == os.Stderr && time.Since(f.lastRetry) >= reopenInterval {
+		f.lastRetry = time.Now()
+		if f.open() == nil {
+			fmt.Fprintf(os.Stderr, "logfile: logging to %s again\n", f.path)
+		}
+	}
+
+	if f.file != os.Stderr && f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		err := f.rotate()
+		if err != nil {
+			// The write still goes ahead, to the new file or to standard error, so that's where the problem is reported.
+			fmt.Fprintf(os.Stderr, "logfile: rotating %s: %v\n", f.path, err)
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+
+	return n, err
+}
+
+// rotate closes the current file, shifts the backups along and opens a new, empty file. Whatever goes wrong,
+// the log file is opened again so that logging carries on. If even that fails, the File falls back to standard
+// error, so that it always has somewhere to write to.
+func (f *File) rotate() error {
+	closeErr := f.file.Close()
+	shiftErr := f.shiftBackups()
+
+	openErr := f.open()
+	if openErr != nil {
+		f.file = os.Stderr
+		f.size = 0
+		f.lastRetry = time.Now()
+	}
+
+	return errors.Join(closeErr, shiftErr, openErr)
+}
+
+func (f *File) shiftBackups() error {
+	if f.maxBackups <= 0 {
+		err := os.Remove(f.path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	// Renaming over the oldest backup removes it.
+	for i := f.maxBackups - 1; i >= 1; i-- {
+		err := os.Rename(backupName(f.path, i), backupName(f.path, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return os.Rename(f.path, backupName(f.path, 1))
+}
+
+func backupName(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+// Close closes the file.
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == os.Stderr {
+		return nil
+	}
+
+	return f.file.Close()
+}
@@ -0,0 +1,96 @@
+// Package markdown renders a small, safe subset of Markdown ("Markdown-lite") to HTML.
+//
+// It supports paragraphs, line breaks, fenced code blocks, `inline code`, **bold**, *italic*
+// and [links](https://example.com) to http and https URLs. Everything else is shown as plain text:
+// the input is HTML-escaped before any formatting is applied, so it can never inject markup.
+package markdown
+
+import (
+	"html"
+	"html/template"
+	"regexp"
+	"strings"
+)
+
+var (
+	boldRX   = regexp.MustCompile(`\*\*([^*\n]+)\*\*`)
+	italicRX = regexp.MustCompile(`\*([^*\n]+)\*`)
+	linkRX   = regexp.MustCompile(`\[([^\]\n]+)\]\((https?://[^\s)]+)\)`)
+)
+
+// Render converts Markdown-lite text to HTML.
+func Render(text string) template.HTML {
+	var b strings.Builder
+
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+
+	// Fences split the text into alternating prose and code blocks.
+	// An unterminated fence simply runs to the end of the text.
+	for i, block := range strings.Split(text, "```") {
+		if i%2 == 1 {
+			// Drop the language hint (like ```go) on the opening line of the fence.
+			if nl := strings.IndexByte(block, '\n'); nl >= 0 && !strings.ContainsAny(block[:nl], " \t") {
+				block = block[nl+1:]
+			}
+			b.WriteString("<pre><code>" + html.EscapeString(strings.Trim(block, "\n")) + "</code></pre>")
+			continue
+		}
+
+		for _, paragraph := range strings.Split(block, "\n\n") {
+			paragraph = strings.Trim(paragraph, "\n")
+			if strings.TrimSpace(paragraph) == "" {
+				continue
+			}
+			b.WriteString("<p>" + renderInline(paragraph) + "</p>")
+		}
+	}
+
+	return template.HTML(b.String())
+}
+
+// renderInline formats a paragraph. Backticks split it into alternating text and inline code,
+// so that nothing inside `code` is ever formatted.
+func renderInline(paragraph string) string {
+	var b strings.Builder
+
+	parts := strings.Split(paragraph, "`")
+	for i, part := range parts {
+		// An odd number of backticks leaves the last one unmatched, so show it as it is.
+		if i%2 == 1 && i < len(parts)-1 {
+			b.WriteString("<code>" + html.EscapeString(part) + "</code>")
+			continue
+		}
+		if i%2 == 1 {
+			b.WriteString("`")
+		}
+
+		s := renderLinks(html.EscapeString(part))
+		s = strings.ReplaceAll(s, "\n", "<br>")
+		b.WriteString(s)
+	}
+
+	return b.String()
+}
+
+// renderLinks turns the links in escaped text into anchors. The links are taken out before any emphasis
+// is applied, so that the asterisks in a URL are left alone, and only the text of a link and the text
+// around it are formatted.
+func renderLinks(s string) string {
+	var b strings.Builder
+
+	last := 0
+	for _, m := range linkRX.FindAllStringSubmatchIndex(s, -1) {
+		b.WriteString(renderEmphasis(s[last:m[0]]))
+		b.WriteString(`<a href="` + s[m[4]:m[5]] + `" rel="nofollow noopener">` + renderEmphasis(s[m[2]:m[3]]) + `</a>`)
+		last = m[1]
+	}
+	b.WriteString(renderEmphasis(s[last:]))
+
+	return b.String()
+}
+
+// renderEmphasis formats **bold** and *italic* text.
+func renderEmphasis(s string) string {
+	s = boldRX.ReplaceAllString(s, "<strong>$1</strong>")
+	return italicRX.ReplaceAllString(s, "<em>$1</em>")
+}
@@ -0,0 +1,76 @@
+package markdown
+
+import "testing"
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "Paragraphs and line breaks",
+			input: "one\ntwo\n\nthree",
+			want:  "<p>one<br>two</p><p>three</p>",
+		},
+		{
+			name:  "Emphasis",
+			input: "**bold** and *italic*",
+			want:  "<p><strong>bold</strong> and <em>italic</em></p>",
+		},
+		{
+			name:  "HTML is escaped",
+			input: `<script>alert("x")</script>`,
+			want:  "<p>&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt;</p>",
+		},
+		{
+			name:  "Link",
+			input: "[Go](https://go.dev/doc)",
+			want:  `<p><a href="https://go.dev/doc" rel="nofollow noopener">Go</a></p>`,
+		},
+		{
+			name:  "Emphasis in link text",
+			input: "[**Go**](https://go.dev)",
+			want:  `<p><a href="https://go.dev" rel="nofollow noopener"><strong>Go</strong></a></p>`,
+		},
+		{
+			name:  "No emphasis inside a link target",
+			input: "[x](http://a/**b**) and [y](http://a/*c*)",
+			want:  `<p><a href="http://a/**b**" rel="nofollow noopener">x</a> and <a href="http://a/*c*" rel="nofollow noopener">y</a></p>`,
+		},
+		{
+			name:  "No emphasis across a link",
+			input: "*a [x](http://a/*) b*",
+			want:  `<p>*a <a href="http://a/*" rel="nofollow noopener">x</a> b*</p>`,
+		},
+		{
+			name:  "Quotes can't break out of a link target",
+			input: `[x](https://a/"onmouseover="alert(1))`,
+			want:  `<p><a href="https://a/&#34;onmouseover=&#34;alert(1" rel="nofollow noopener">x</a>)</p>`,
+		},
+		{
+			name:  "Only http and https links",
+			input: "[x](javascript:alert(1))",
+			want:  "<p>[x](javascript:alert(1))</p>",
+		},
+		{
+			name:  "Nothing is formatted in inline code",
+			input: "`**a** [x](https://a)`",
+			want:  "<p><code>**a** [x](https://a)</code></p>",
+		},
+		{
+			name:  "Fenced code block",
+			input: "```go\nfmt.Println(\"<hi>\")\n```",
+			want:  "<pre><code>fmt.Println(&#34;&lt;hi&gt;&#34;)</code></pre>",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(Render(tt.input))
+			if got != tt.want {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
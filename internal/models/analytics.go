@@ -0,0 +1,178 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// The kinds of access that are counted for every snippet.
+const (
+	ViewKindPage = "page"
+	ViewKindRaw  = "raw"
+)
+
+// Define an AnalyticsModel type which wraps a sql.DB connection pool. Views are stored already
+// aggregated per snippet and day, rather than as one row per request:
+//
+//	CREATE TABLE snippet_views (
+//	    snippet_id INTEGER NOT NULL,
+//	    day DATE NOT NULL,
+//	    kind ENUM('page', 'raw') NOT NULL,
+//	    views INTEGER NOT NULL,
+//	    PRIMARY KEY (snippet_id, day, kind),
+//	    CONSTRAINT fk_snippet_views_snippet FOREIGN KEY (snippet_id) REFERENCES snippets(id) ON DELETE CASCADE
+//	);
+//
+//	CREATE TABLE snippet_visitors (
+//	    snippet_id INTEGER NOT NULL,
+//	    day DATE NOT NULL,
+//	    visitor CHAR(64) NOT NULL,
+//	    PRIMARY KEY (snippet_id, day, visitor),
+//	    CONSTRAINT fk_snippet_visitors_snippet FOREIGN KEY (snippet_id) REFERENCES snippets(id) ON DELETE CASCADE
+//	);
+//
+//	CREATE TABLE snippet_referrers (
+//	    snippet_id INTEGER NOT NULL,
+//	    day DATE NOT NULL,
+//	    host VARCHAR(255) NOT NULL,
+//	    views INTEGER NOT NULL,
+//	    PRIMARY KEY (snippet_id, day, host),
+//	    CONSTRAINT fk_snippet_referrers_snippet FOREIGN KEY (snippet_id) REFERENCES snippets(id) ON DELETE CASCADE
+//	);
+type AnalyticsModel struct {
+	DB *sql.DB
+}
+
+// ViewCount is the number of views of one kind that a snippet had on a day.
+type ViewCount struct {
+	SnippetID int
+	Day       time.Time
+	Kind      string
+	Views     int
+}
+
+// VisitorHit records that a visitor, identified by a hash, viewed a snippet on a day.
+type VisitorHit struct {
+	SnippetID int
+	Day       time.Time
+	Visitor   string
+}
+
+// ReferrerCount is the number of views a snippet had from one referring host on a day.
+type ReferrerCount struct {
+	SnippetID int
+	Day       time.Time
+	Host      string
+	Views     int
+}
+
+// DailyStats holds the aggregated views of a snippet on one day.
+type DailyStats struct {
+	Day      time.Time
+	Views    int
+	RawViews int
+	Visitors int
+}
+
+// Save adds a batch of aggregated counts to the stored ones, in a single transaction.
+func (am *AnalyticsModel) Save(views []ViewCount, visitors []VisitorHit, referrers []ReferrerCount) error {
+	tx, err := am.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, v := range views {
+		_, err := tx.Exec(`INSERT INTO snippet_views (snippet_id, day, kind, views) VALUES(?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE views = views + VALUES(views)`, v.SnippetID, v.Day, v.Kind, v.Views)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, v := range visitors {
+		_, err := tx.Exec(`INSERT IGNORE INTO snippet_visitors (snippet_id, day, visitor) VALUES(?, ?, ?)`,
+			v.SnippetID, v.Day, v.Visitor)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, r := range referrers {
+		_, err := tx.Exec(`INSERT INTO snippet_referrers (snippet_id, day, host, views) VALUES(?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE views = views + VALUES(views)`, r.SnippetID, r.Day, r.Host, r.Views)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Daily returns the stats of a snippet for each of the last days days that it had any views, newest first.
+func (am *AnalyticsModel) Daily(snippetID, days int) ([]DailyStats, error) {
+	queryStmt := `SELECT v.day,
+		SUM(CASE WHEN v.kind = 'page' THEN v.views ELSE 0 END),
+		SUM(CASE WHEN v.kind = 'raw' THEN v.views ELSE 0 END),
+		(SELECT COUNT(*) FROM snippet_visitors u WHERE u.snippet_id = v.snippet_id AND u.day = v.day)
+	FROM snippet_views v
+	WHERE v.snippet_id = ? AND v.day > DATE_SUB(UTC_DATE(), INTERVAL ? DAY)
+	GROUP BY v.snippet_id, v.day ORDER BY v.day DESC`
+
+	rows, err := am.DB.Query(queryStmt, snippetID, days)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []DailyStats
+
+	for rows.Next() {
+		var s DailyStats
+
+		err := rows.Scan(&s.Day, &s.Views, &s.RawViews, &s.Visitors)
+		if err != nil {
+			return nil, err
+		}
+
+		stats = append(stats, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// TopReferrers returns the hosts that referred the most views to a snippet in the last days days.
+func (am *AnalyticsModel) TopReferrers(snippetID, days, limit int) ([]ReferrerCount, error) {
+	queryStmt := `SELECT host, SUM(views) AS total FROM snippet_referrers
+	WHERE snippet_id = ? AND day > DATE_SUB(UTC_DATE(), INTERVAL ? DAY)
+	GROUP BY host ORDER BY total DESC LIMIT ?`
+
+	rows, err := am.DB.Query(queryStmt, snippetID, days, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var referrers []ReferrerCount
+
+	for rows.Next() {
+		r := ReferrerCount{SnippetID: snippetID}
+
+		err := rows.Scan(&r.Host, &r.Views)
+		if err != nil {
+			return nil, err
+		}
+
+		referrers = append(referrers, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return referrers, nil
+}
@@ -0,0 +1,102 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Define an Attachment type to hold the metadata of a file attached to a snippet.
+// The content itself lives in a storage.BlobStore, under BlobKey.
+//
+//	CREATE TABLE attachments (
+//	    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+//	    snippet_id INTEGER NOT NULL,
+//	    name VARCHAR(255) NOT NULL,
+//	    content_type VARCHAR(100) NOT NULL,
+//	    size BIGINT NOT NULL,
+//	    blob_key VARCHAR(100) NOT NULL,
+//	    created_at DATETIME NOT NULL,
+//	    CONSTRAINT fk_attachments_snippet FOREIGN KEY (snippet_id) REFERENCES snippets(id) ON DELETE CASCADE
+//	);
+type Attachment struct {
+	ID          int
+	SnippetID   int
+	Name        string
+	ContentType string
+	Size        int64
+	BlobKey     string
+	CreatedAt   time.Time
+}
+
+// Define an AttachmentModel type which wraps a sql.DB connection pool.
+type AttachmentModel struct {
+	DB *sql.DB
+}
+
+// Insert records an attachment whose content has already been stored under blobKey.
+func (am *AttachmentModel) Insert(snippetID int, name, contentType string, size int64, blobKey string) (int, error) {
+	queryStmt := `INSERT INTO attachments (snippet_id, name, content_type, size, blob_key, created_at)
+	VALUES(?, ?, ?, ?, ?, UTC_TIMESTAMP())`
+
+	result, err := am.DB.Exec(queryStmt, snippetID, name, contentType, size, blobKey)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// Get returns the attachment with the given ID, as long as it belongs to the given snippet.
+func (am *AttachmentModel) Get(snippetID, id int) (Attachment, error) {
+	queryStmt := `SELECT id, snippet_id, name, content_type, size, blob_key, created_at FROM attachments
+	WHERE snippet_id = ? AND id = ?`
+
+	var a Attachment
+
+	err := am.DB.QueryRow(queryStmt, snippetID, id).Scan(&a.ID, &a.SnippetID, &a.Name, &a.ContentType, &a.Size, &a.BlobKey, &a.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Attachment{}, ErrNoRecord
+		}
+		return Attachment{}, err
+	}
+
+	return a, nil
+}
+
+// ForSnippet returns all the attachments of a snippet, in the order they were uploaded.
+func (am *AttachmentModel) ForSnippet(snippetID int) ([]Attachment, error) {
+	queryStmt := `SELECT id, snippet_id, name, content_type, size, blob_key, created_at FROM attachments
+	WHERE snippet_id = ? ORDER BY id`
+
+	rows, err := am.DB.Query(queryStmt, snippetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attachments []Attachment
+
+	for rows.Next() {
+		var a Attachment
+
+		err := rows.Scan(&a.ID, &a.SnippetID, &a.Name, &a.ContentType, &a.Size, &a.BlobKey, &a.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		attachments = append(attachments, a)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return attachments, nil
+}
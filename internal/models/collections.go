@@ -0,0 +1,307 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// The most snippets a single collection can hold.
+const MaxCollectionSnippets = 100
+
+// Define a Collection type to hold a named, ordered list of snippets, like the steps of a runbook.
+// Collections are addressed by a random slug, just like snippets, and have a visibility of their own.
+// The snippets in them are kept in a join table, in the order given by their position:
+//
+//	CREATE TABLE collections (
+//	    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+//	    slug CHAR(12) NOT NULL,
+//	    title VARCHAR(100) NOT NULL,
+//	    description TEXT NOT NULL,
+//	    visibility ENUM('public', 'unlisted', 'private') NOT NULL DEFAULT 'public',
+//	    owner_id CHAR(64) NOT NULL,
+//	    created_at DATETIME NOT NULL,
+//	    updated_at DATETIME NOT NULL
+//	);
+//	CREATE UNIQUE INDEX idx_collections_slug ON collections(slug);
+//	CREATE INDEX idx_collections_owner ON collections(owner_id);
+//
+//	CREATE TABLE collection_snippets (
+//	    collection_id INTEGER NOT NULL,
+//	    snippet_id INTEGER NOT NULL,
+//	    position INTEGER NOT NULL,
+//	    PRIMARY KEY (collection_id, snippet_id),
+//	    CONSTRAINT fk_collection_snippets_collection FOREIGN KEY (collection_id) REFERENCES collections(id) ON DELETE CASCADE,
+//	    CONSTRAINT fk_collection_snippets_snippet FOREIGN KEY (snippet_id) REFERENCES snippets(id) ON DELETE CASCADE
+//	);
+type Collection struct {
+	ID          int
+	Slug        string
+	Title       string
+	Description string
+	Visibility  Visibility
+	OwnerID     string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// Path returns the URL path of the page that displays the collection.
+func (c Collection) Path() string {
+	return "/collection/view/" + c.Slug
+}
+
+// Define a CollectionModel type which wraps a sql.DB connection pool.
+// The snippets of a collection are loaded with SnippetModel.InCollection().
+type CollectionModel struct {
+	DB *sql.DB
+}
+
+// Insert creates a new, empty collection.
+func (cm *CollectionModel) Insert(title, description string, visibility Visibility, ownerID string) (Collection, error) {
+	queryStmt := `INSERT INTO collections (slug, title, description, visibility, owner_id, created_at, updated_at)
+	VALUES(?, ?, ?, ?, ?, UTC_TIMESTAMP(), UTC_TIMESTAMP())`
+
+	for attempt := 1; ; attempt++ {
+		slug, err := newSlug()
+		if err != nil {
+			return Collection{}, err
+		}
+
+		result, err := cm.DB.Exec(queryStmt, slug, title, description, visibility, ownerID)
+		if err != nil {
+			// Retry with a new slug if this one is taken, as SnippetModel.Insert() does.
+			var mySQLError *mysql.MySQLError
+			if errors.As(err, &mySQLError) && mySQLError.Number == 1062 &&
+				strings.Contains(mySQLError.Message, "idx_collections_slug") && attempt < maxSlugAttempts {
+				continue
+			}
+
+			return Collection{}, err
+		}
+
+		id, err := result.LastInsertId()
+		if err != nil {
+			return Collection{}, err
+		}
+
+		return cm.getOne(`SELECT `+collectionColumns+` FROM collections WHERE id = ?`, id)
+	}
+}
+
+// Update changes the title, description and visibility of a collection.
+func (cm *CollectionModel) Update(id int, title, description string, visibility Visibility) error {
+	queryStmt := `UPDATE collections SET title = ?, description = ?, visibility = ?, updated_at = UTC_TIMESTAMP() WHERE id = ?`
+
+	_, err := cm.DB.Exec(queryStmt, title, description, visibility, id)
+	return err
+}
+
+// Delete removes a collection. The snippets in it are left alone.
+func (cm *CollectionModel) Delete(id int) error {
+	_, err := cm.DB.Exec(`DELETE FROM collections WHERE id = ?`, id)
+	return err
+}
+
+// The columns every collection query selects, in the order that scanCollection() expects them.
+const collectionColumns = `id, slug, title, description, visibility, owner_id, created_at, updated_at`
+
+func scanCollection(row scanner) (Collection, error) {
+	var c Collection
+
+	err := row.Scan(&c.ID, &c.Slug, &c.Title, &c.Description, &c.Visibility, &c.OwnerID, &c.CreatedAt, &c.UpdatedAt)
+	return c, err
+}
+
+// GetBySlug returns the collection with the given slug, whatever its visibility.
+// It's up to the caller to check whether the current visitor may see it.
+func (cm *CollectionModel) GetBySlug(slug string) (Collection, error) {
+	return cm.getOne(`SELECT `+collectionColumns+` FROM collections WHERE slug = ?`, slug)
+}
+
+func (cm *CollectionModel) getOne(queryStmt string, args ...any) (Collection, error) {
+	c, err := scanCollection(cm.DB.QueryRow(queryStmt, args...))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Collection{}, ErrNoRecord
+		}
+		return Collection{}, err
+	}
+
+	return c, nil
+}
+
+// ByOwner returns all the collections of an owner, most recently updated first.
+func (cm *CollectionModel) ByOwner(ownerID string) ([]Collection, error) {
+	queryStmt := `SELECT ` + collectionColumns + ` FROM collections WHERE owner_id = ? ORDER BY updated_at DESC, id DESC`
+
+	rows, err := cm.DB.Query(queryStmt, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var collections []Collection
+
+	for rows.Next() {
+		c, err := scanCollection(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		collections = append(collections, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return collections, nil
+}
+
+// AddSnippet appends a snippet to the end of a collection.
+// Adding a snippet that is already in the collection does nothing.
+func (cm *CollectionModel) AddSnippet(collectionID, snippetID int) error {
+	tx, err := cm.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the collection's row, so that two snippets added at the same time don't get the same position.
+	_, err = tx.Exec(`SELECT id FROM collections WHERE id = ? FOR UPDATE`, collectionID)
+	if err != nil {
+		return err
+	}
+
+	var count, last int
+
+	err = tx.QueryRow(`SELECT COUNT(*), COALESCE(MAX(position), 0) FROM collection_snippets WHERE collection_id = ?`,
+		collectionID).Scan(&count, &last)
+	if err != nil {
+		return err
+	}
+
+	if count >= MaxCollectionSnippets {
+		return ErrCollectionFull
+	}
+
+	_, err = tx.Exec(`INSERT IGNORE INTO collection_snippets (collection_id, snippet_id, position) VALUES(?, ?, ?)`,
+		collectionID, snippetID, last+1)
+	if err != nil {
+		return err
+	}
+
+	err = touchCollection(tx, collectionID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RemoveSnippet takes a snippet out of a collection. Removing a snippet that isn't in it does nothing.
+func (cm *CollectionModel) RemoveSnippet(collectionID, snippetID int) error {
+	tx, err := cm.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM collection_snippets WHERE collection_id = ? AND snippet_id = ?`, collectionID, snippetID)
+	if err != nil {
+		return err
+	}
+
+	err = touchCollection(tx, collectionID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Reorder moves the given snippets, in the given order, to the start of a collection. Any snippets of the
+// collection that aren't listed (like ones that have expired, or that the caller can't see) keep their
+// relative order after them. ErrInvalidOrder is returned if snippetIDs lists a snippet twice,
+// or one that isn't in the collection.
+func (cm *CollectionModel) Reorder(collectionID int, snippetIDs []int) error {
+	tx, err := cm.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	current, err := snippetIDsIn(tx, collectionID)
+	if err != nil {
+		return err
+	}
+
+	listed := make(map[int]bool, len(snippetIDs))
+	for _, id := range snippetIDs {
+		if listed[id] || !slices.Contains(current, id) {
+			return ErrInvalidOrder
+		}
+		listed[id] = true
+	}
+
+	order := slices.Clone(snippetIDs)
+	for _, id := range current {
+		if !listed[id] {
+			order = append(order, id)
+		}
+	}
+
+	for i, id := range order {
+		_, err := tx.Exec(`UPDATE collection_snippets SET position = ? WHERE collection_id = ? AND snippet_id = ?`,
+			i+1, collectionID, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = touchCollection(tx, collectionID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// snippetIDsIn returns the IDs of the snippets in a collection in their order,
+// locking their rows for the rest of the transaction.
+func snippetIDsIn(tx *sql.Tx, collectionID int) ([]int, error) {
+	rows, err := tx.Query(`SELECT snippet_id FROM collection_snippets WHERE collection_id = ? ORDER BY position FOR UPDATE`, collectionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+
+	for rows.Next() {
+		var id int
+
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// touchCollection records that the snippets of a collection have changed.
+func touchCollection(tx *sql.Tx, collectionID int) error {
+	_, err := tx.Exec(`UPDATE collections SET updated_at = UTC_TIMESTAMP() WHERE id = ?`, collectionID)
+	return err
+}
@@ -0,0 +1,191 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Define a Comment type to hold a comment on a snippet. Comments form threads: a top-level comment
+// has no parent, and every reply records both its direct parent and the top-level comment of its thread,
+// so that a whole thread can be loaded with one query. A comment can be anchored to a line of one of
+// the snippet's files (FileIndex is the position of the file, Line counts from 1, and 0 means no line).
+//
+//	CREATE TABLE comments (
+//	    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+//	    snippet_id INTEGER NOT NULL,
+//	    parent_id INTEGER NULL,
+//	    root_id INTEGER NULL,
+//	    file_index INTEGER NOT NULL DEFAULT 0,
+//	    line INTEGER NOT NULL DEFAULT 0,
+//	    author VARCHAR(50) NOT NULL,
+//	    owner_id CHAR(64) NOT NULL,
+//	    body TEXT NOT NULL,
+//	    created_at DATETIME NOT NULL,
+//	    CONSTRAINT fk_comments_snippet FOREIGN KEY (snippet_id) REFERENCES snippets(id) ON DELETE CASCADE,
+//	    CONSTRAINT fk_comments_parent FOREIGN KEY (parent_id) REFERENCES comments(id) ON DELETE CASCADE
+//	);
+//	CREATE INDEX idx_comments_thread ON comments(snippet_id, root_id, id);
+type Comment struct {
+	ID        int
+	SnippetID int
+	ParentID  int
+	RootID    int
+	FileIndex int
+	Line      int
+	Author    string
+	OwnerID   string
+	Body      string
+	CreatedAt time.Time
+	Replies   []*Comment
+}
+
+// Define a CommentModel type which wraps a sql.DB connection pool.
+type CommentModel struct {
+	DB *sql.DB
+}
+
+// Insert adds a comment to a snippet. If parentID is not 0 the comment is a reply to that comment,
+// which must belong to the same snippet.
+func (cm *CommentModel) Insert(snippetID, parentID, fileIndex, line int, author, ownerID, body string) (int, error) {
+	var parent, root sql.NullInt64
+
+	if parentID != 0 {
+		p, err := cm.Get(snippetID, parentID)
+		if err != nil {
+			return 0, err
+		}
+
+		parent = sql.NullInt64{Int64: int64(p.ID), Valid: true}
+		root = sql.NullInt64{Int64: int64(p.RootID), Valid: true}
+		if p.RootID == 0 {
+			root.Int64 = int64(p.ID)
+		}
+	}
+
+	queryStmt := `INSERT INTO comments (snippet_id, parent_id, root_id, file_index, line, author, owner_id, body, created_at)
+	VALUES(?, ?, ?, ?, ?, ?, ?, ?, UTC_TIMESTAMP())`
+
+	result, err := cm.DB.Exec(queryStmt, snippetID, parent, root, fileIndex, line, author, ownerID, body)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// The columns every comment query selects, in the order that scanComment() expects them.
+const commentColumns = `id, snippet_id, parent_id, root_id, file_index, line, author, owner_id, body, created_at`
+
+func scanComment(row scanner) (*Comment, error) {
+	var (
+		c            Comment
+		parent, root sql.NullInt64
+	)
+
+	err := row.Scan(&c.ID, &c.SnippetID, &parent, &root, &c.FileIndex, &c.Line, &c.Author, &c.OwnerID, &c.Body, &c.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	c.ParentID = int(parent.Int64)
+	c.RootID = int(root.Int64)
+
+	return &c, nil
+}
+
+// Get returns the comment with the given ID, as long as it belongs to the given snippet.
+func (cm *CommentModel) Get(snippetID, id int) (*Comment, error) {
+	queryStmt := `SELECT ` + commentColumns + ` FROM comments WHERE snippet_id = ? AND id = ?`
+
+	c, err := scanComment(cm.DB.QueryRow(queryStmt, snippetID, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Threads returns one page of a snippet's top-level comments, newest first, each with all of its replies
+// nested below it (oldest first). It also returns the total number of top-level comments.
+// Pages are numbered from 1.
+func (cm *CommentModel) Threads(snippetID, page, perPage int) ([]*Comment, int, error) {
+	var total int
+
+	err := cm.DB.QueryRow(`SELECT COUNT(*) FROM comments WHERE snippet_id = ? AND parent_id IS NULL`, snippetID).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	queryStmt := `SELECT ` + commentColumns + ` FROM comments
+	WHERE snippet_id = ? AND parent_id IS NULL ORDER BY id DESC LIMIT ? OFFSET ?`
+
+	threads, err := cm.query(queryStmt, snippetID, perPage, (page-1)*perPage)
+	if err != nil || len(threads) == 0 {
+		return threads, total, err
+	}
+
+	// Load the replies of all the threads on this page in one go, and hang each one below its parent.
+	// Ordering by ID guarantees that a parent is always seen before its replies.
+	byID := make(map[int]*Comment, len(threads))
+	args := []any{snippetID}
+	placeholders := ""
+	for i, t := range threads {
+		byID[t.ID] = t
+		args = append(args, t.ID)
+		if i > 0 {
+			placeholders += ", "
+		}
+		placeholders += "?"
+	}
+
+	queryStmt = `SELECT ` + commentColumns + ` FROM comments
+	WHERE snippet_id = ? AND root_id IN (` + placeholders + `) ORDER BY id`
+
+	replies, err := cm.query(queryStmt, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for _, r := range replies {
+		byID[r.ID] = r
+		if parent, ok := byID[r.ParentID]; ok {
+			parent.Replies = append(parent.Replies, r)
+		}
+	}
+
+	return threads, total, nil
+}
+
+func (cm *CommentModel) query(queryStmt string, args ...any) ([]*Comment, error) {
+	rows, err := cm.DB.Query(queryStmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var comments []*Comment
+
+	for rows.Next() {
+		c, err := scanComment(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		comments = append(comments, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return comments, nil
+}
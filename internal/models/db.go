@@ -0,0 +1,21 @@
+package models
+
+import "database/sql"
+
+// The OpenDB() function wraps sql.Open()
+// and returns a sql.DB connection pool for a given DSN.
+// It's shared by the web application and the rotatekeys command.
+func OpenDB(dsn string) (*sql.DB, error) {
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
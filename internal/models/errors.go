@@ -3,3 +3,9 @@ package models
 import "errors"
 
 var ErrNoRecord = errors.New("model: no matching record found")
+
+// ErrCollectionFull is returned when a snippet is added to a collection that already holds MaxCollectionSnippets.
+var ErrCollectionFull = errors.New("model: collection is full")
+
+// ErrInvalidOrder is returned when a new order for a collection lists a snippet twice, or one that isn't in it.
+var ErrInvalidOrder = errors.New("model: invalid order of snippets in collection")
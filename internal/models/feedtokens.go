@@ -0,0 +1,77 @@
+package models
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+)
+
+// The length of a feed token, in characters. Like a slug it's URL-safe base64, of 192 random bits.
+const FeedTokenLength = 32
+
+// Define a FeedTokenModel type which wraps a sql.DB connection pool. A feed token stands in for an owner
+// in the URL of their feed, so that sharing the feed doesn't give away the owner ID, which would link all
+// of their snippets together. Each owner has at most one token, and replacing it revokes the old one:
+//
+//	CREATE TABLE feed_tokens (
+//	    owner_id CHAR(64) NOT NULL PRIMARY KEY,
+//	    token CHAR(32) NOT NULL,
+//	    created_at DATETIME NOT NULL,
+//	    CONSTRAINT idx_feed_tokens_token UNIQUE (token)
+//	);
+type FeedTokenModel struct {
+	DB *sql.DB
+}
+
+// Get returns the feed token of an owner, or ErrNoRecord if they don't have one.
+func (fm *FeedTokenModel) Get(ownerID string) (string, error) {
+	var token string
+
+	err := fm.DB.QueryRow(`SELECT token FROM feed_tokens WHERE owner_id = ?`, ownerID).Scan(&token)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNoRecord
+	}
+
+	return token, err
+}
+
+// Reset gives an owner a new feed token, replacing the old one if they had one.
+func (fm *FeedTokenModel) Reset(ownerID string) (string, error) {
+	b := make([]byte, FeedTokenLength/4*3)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	queryStmt := `INSERT INTO feed_tokens (owner_id, token, created_at) VALUES(?, ?, UTC_TIMESTAMP())
+	ON DUPLICATE KEY UPDATE token = VALUES(token), created_at = VALUES(created_at)`
+
+	_, err = fm.DB.Exec(queryStmt, ownerID, token)
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// Delete revokes the feed token of an owner. Deleting a token that doesn't exist does nothing.
+func (fm *FeedTokenModel) Delete(ownerID string) error {
+	_, err := fm.DB.Exec(`DELETE FROM feed_tokens WHERE owner_id = ?`, ownerID)
+	return err
+}
+
+// Owner returns the owner ID that a feed token belongs to, or ErrNoRecord if it isn't a current token.
+func (fm *FeedTokenModel) Owner(token string) (string, error) {
+	var ownerID string
+
+	err := fm.DB.QueryRow(`SELECT owner_id FROM feed_tokens WHERE token = ?`, token).Scan(&ownerID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNoRecord
+	}
+
+	return ownerID, err
+}
@@ -0,0 +1,144 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"path"
+	"strings"
+)
+
+// Define a File type to hold one of the named files that make up a snippet.
+//
+// The first file of a snippet is stored in the snippets table itself, in the content column
+// and two more columns for its name and language:
+//
+//	ALTER TABLE snippets ADD COLUMN file_name VARCHAR(255) NOT NULL DEFAULT '';
+//	ALTER TABLE snippets ADD COLUMN language VARCHAR(32) NOT NULL DEFAULT '';
+//
+// Any further files are stored, in order, in their own table:
+//
+//	CREATE TABLE snippet_files (
+//	    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+//	    snippet_id INTEGER NOT NULL,
+//	    position INTEGER NOT NULL,
+//	    name VARCHAR(255) NOT NULL,
+//	    language VARCHAR(32) NOT NULL,
+//	    content MEDIUMTEXT NOT NULL,
+//	    data_key VARBINARY(60) NULL,
+//	    key_id CHAR(16) NULL,
+//	    CONSTRAINT fk_snippet_files_snippet FOREIGN KEY (snippet_id) REFERENCES snippets(id) ON DELETE CASCADE,
+//	    CONSTRAINT uq_snippet_files_position UNIQUE (snippet_id, position)
+//	);
+type File struct {
+	Name     string
+	Language string
+	Content  string
+}
+
+// The name given to the single file of snippets created before they could hold several files.
+const defaultFileName = "snippet.txt"
+
+// Languages holds the languages a file can be marked as, keyed by the name used
+// in the language-* CSS class, with the label shown to the user as the value.
+var Languages = map[string]string{
+	"":           "Plain text",
+	"bash":       "Shell",
+	"c":          "C",
+	"css":        "CSS",
+	"dockerfile": "Dockerfile",
+	"go":         "Go",
+	"html":       "HTML",
+	"java":       "Java",
+	"javascript": "JavaScript",
+	"json":       "JSON",
+	"markdown":   "Markdown",
+	"python":     "Python",
+	"rust":       "Rust",
+	"sql":        "SQL",
+	"typescript": "TypeScript",
+	"yaml":       "YAML",
+}
+
+// The languages DetectLanguage() recognises by file extension.
+var extensionLanguages = map[string]string{
+	".sh":   "bash",
+	".c":    "c",
+	".h":    "c",
+	".css":  "css",
+	".go":   "go",
+	".mod":  "go",
+	".html": "html",
+	".java": "java",
+	".js":   "javascript",
+	".json": "json",
+	".md":   "markdown",
+	".py":   "python",
+	".rs":   "rust",
+	".sql":  "sql",
+	".ts":   "typescript",
+	".yaml": "yaml",
+	".yml":  "yaml",
+}
+
+// DetectLanguage guesses the language of a file from its name, returning "" (plain text) if it can't.
+func DetectLanguage(name string) string {
+	if strings.EqualFold(name, "Dockerfile") {
+		return "dockerfile"
+	}
+
+	return extensionLanguages[strings.ToLower(path.Ext(name))]
+}
+
+// insertFiles stores all but the first of a snippet's files, which Insert() already stored in the snippets row.
+func (sm *SnippetModel) insertFiles(ctx context.Context, tx *sql.Tx, snippetID int, files []File) error {
+	queryStmt := `INSERT INTO snippet_files (snippet_id, position, name, language, content, data_key, key_id)
+	VALUES(?, ?, ?, ?, ?, ?, ?)`
+
+	for i := 1; i < len(files); i++ {
+		content, dataKey, keyID, err := sm.sealContent(files[i].Content)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, queryStmt, snippetID, i, files[i].Name, files[i].Language, content, dataKey, keyID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadFiles appends the files from the snippet_files table to the snippet's first file.
+func (sm *SnippetModel) loadFiles(ctx context.Context, snippet *Snippet) error {
+	queryStmt := `SELECT name, language, content, data_key, key_id FROM snippet_files
+	WHERE snippet_id = ? ORDER BY position`
+
+	rows, err := sm.DB.QueryContext(ctx, queryStmt, snippet.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			file    File
+			dataKey []byte
+			keyID   sql.NullString
+		)
+
+		err := rows.Scan(&file.Name, &file.Language, &file.Content, &dataKey, &keyID)
+		if err != nil {
+			return err
+		}
+
+		file.Content, err = sm.openContent(file.Content, dataKey, keyID.String)
+		if err != nil {
+			return err
+		}
+
+		snippet.Files = append(snippet.Files, file)
+	}
+
+	return rows.Err()
+}
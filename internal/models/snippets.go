@@ -1,70 +1,420 @@
 package models
 
 import (
+	"context"
+	"crypto/rand"
 	"database/sql"
+	"encoding/base64"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"golang.org/x/crypto/bcrypt"
+	"snippetbox.t10i.net/internal/envelope"
+)
+
+// Define a Visibility type to describe who is allowed to see a snippet.
+// The snippets table stores it in a visibility column, added with:
+//
+//	ALTER TABLE snippets ADD COLUMN visibility ENUM('public', 'unlisted', 'private') NOT NULL DEFAULT 'public';
+//	ALTER TABLE snippets ADD COLUMN owner_id CHAR(64) NOT NULL DEFAULT '';
+type Visibility string
+
+const (
+	// Public snippets are listed on the home page and can be viewed by anyone.
+	VisibilityPublic Visibility = "public"
+	// Unlisted snippets are hidden from listings but can be viewed by anyone who has the link.
+	VisibilityUnlisted Visibility = "unlisted"
+	// Private snippets can only be viewed by their owner.
+	VisibilityPrivate Visibility = "private"
 )
 
 // Define a Snippet type to hold the data for an individual snippet.
 // Notice how the fields of the struct correspond to the fields in our MySQL snippets table.
+//
+// Every snippet is addressed by a random Slug rather than its sequential ID, so that snippets can't be
+// enumerated by counting. The slug column and its unique index are added (and back-filled) with:
+//
+//	ALTER TABLE snippets ADD COLUMN slug CHAR(12) NULL;
+//	UPDATE snippets SET slug = REPLACE(REPLACE(TO_BASE64(RANDOM_BYTES(9)), '+', '-'), '/', '_') WHERE slug IS NULL;
+//	ALTER TABLE snippets MODIFY slug CHAR(12) NOT NULL;
+//	CREATE UNIQUE INDEX idx_snippets_slug ON snippets(slug);
+//
+// A snippet can optionally be protected by a password, of which only a bcrypt hash is stored:
+//
+//	ALTER TABLE snippets ADD COLUMN hashed_password CHAR(60) NULL;
+//
+// Encrypted snippets were encrypted in the browser before they were submitted, so their Content
+// holds ciphertext which the server can't read:
+//
+//	ALTER TABLE snippets ADD COLUMN encrypted BOOLEAN NOT NULL DEFAULT FALSE;
+//
+// A snippet is made up of one or more Files. Content always holds the content of the first file.
+//
+// A snippet that was forked from another one records the ID of the original in ForkedFrom (0 if it wasn't):
+//
+//	ALTER TABLE snippets ADD COLUMN forked_from INTEGER NULL,
+//	    ADD CONSTRAINT fk_snippets_forked_from FOREIGN KEY (forked_from) REFERENCES snippets(id) ON DELETE SET NULL;
 type Snippet struct {
-	ID        int
-	Title     string
-	Content   string
-	CreatedAt time.Time
-	ExpiresAt time.Time
+	ID             int
+	Slug           string
+	Title          string
+	Content        string
+	Files          []File
+	Visibility     Visibility
+	OwnerID        string
+	HashedPassword []byte
+	Encrypted      bool
+	ForkedFrom     int
+	Stars          int
+	CreatedAt      time.Time
+	ExpiresAt      time.Time
+}
+
+// Protected reports whether the snippet needs a password before its content can be shown.
+func (s Snippet) Protected() bool {
+	return len(s.HashedPassword) > 0
+}
+
+// CheckPassword reports whether password matches the one the snippet was protected with.
+// Unprotected snippets don't match any password.
+func (s Snippet) CheckPassword(password string) (bool, error) {
+	if !s.Protected() {
+		return false, nil
+	}
+
+	err := bcrypt.CompareHashAndPassword(s.HashedPassword, []byte(password))
+	if err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Path returns the URL path of the page that displays the snippet.
+func (s Snippet) Path() string {
+	return "/snippet/view/" + s.Slug
 }
 
 // Define a SnippetModel type which wraps a sql.DB connection pool.
+//
+// If Keys is set, the content column is encrypted at rest with envelope encryption.
+// Every snippet gets its own data key, which is stored wrapped by the master key in two more columns:
+//
+//	ALTER TABLE snippets ADD COLUMN data_key VARBINARY(60) NULL;
+//	ALTER TABLE snippets ADD COLUMN key_id CHAR(16) NULL;
+//
+// Rows without a data key hold plain-text content. They are still read as normal,
+// and get encrypted the next time RewrapKeys() runs.
+//
+// The query methods take the context of the request, which cancels the query if the client goes away,
+// and record a span for the query in the trace of the request.
 type SnippetModel struct {
-	DB *sql.DB
+	DB   *sql.DB
+	Keys *envelope.Keyring
 }
 
+// The number of times Insert() generates a fresh slug after colliding with an existing one.
+// With 72 random bits per slug a single collision is already astronomically unlikely.
+const maxSlugAttempts = 5
+
 // This will insert a new snippet into the database.
 // Returns:
-// 1. The ID of the newly inserted snippet (integer).
+// 1. The newly inserted snippet, with its ID and slug filled in.
 // 2. An error if something goes wrong.
-func (sm *SnippetModel) Insert(title string, content string, expires_at int) (int, error) {
-	queryStmt := `INSERT INTO snippets (title, content, created_at, expires_at)
-    VALUES(?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY))`
-
-	// Use the Exec() method on the embedded connection pool to execute the statement.
-	// The first parameter is the SQL statement,
-	// followed by the values for the placeholder parameters: title, content and expiry in that order.
-	// This method returns a sql.Result type, which contains some
-	// basic information about what happened when the statement was executed.
-	sqlResult, err := sm.DB.Exec(queryStmt, title, content, expires_at)
+//
+// A snippet must have at least one file.
+// If password is not empty the snippet is protected by it.
+// If encrypted is true, the content of the files is ciphertext produced in the browser.
+// If forkedFrom is not 0, it's the ID of the snippet that the new one is a fork of.
+func (sm *SnippetModel) Insert(ctx context.Context, title string, files []File, expires_at int, visibility Visibility, ownerID string, password string, encrypted bool, forkedFrom int) (_ Snippet, err error) {
+	ctx, span := startSpan(ctx, "SnippetModel.Insert")
+	defer endSpan(span, &err)
+
+	queryStmt := `INSERT INTO snippets (title, file_name, language, content, data_key, key_id, visibility, slug, owner_id, hashed_password, encrypted, forked_from, created_at, expires_at)
+    VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY))`
+
+	if len(files) == 0 {
+		return Snippet{}, errors.New("models: a snippet needs at least one file")
+	}
+
+	snippet := Snippet{
+		Title:      title,
+		Content:    files[0].Content,
+		Files:      files,
+		Visibility: visibility,
+		OwnerID:    ownerID,
+		Encrypted:  encrypted,
+		ForkedFrom: forkedFrom,
+	}
+
+	// A NULL forked_from means the snippet isn't a fork.
+	forkedFromID := sql.NullInt64{Int64: int64(forkedFrom), Valid: forkedFrom != 0}
+
+	// Create a bcrypt hash of the plain-text password. The cost of 12 makes every
+	// guess deliberately slow, which is what protects short passwords against brute-forcing.
+	if password != "" {
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+		if err != nil {
+			return Snippet{}, err
+		}
+		snippet.HashedPassword = hashedPassword
+	}
+
+	// Encrypt the content before it ever reaches the database.
+	storedContent, dataKey, keyID, err := sm.sealContent(files[0].Content)
 	if err != nil {
-		return 0, err
+		return Snippet{}, err
 	}
 
-	// Use the LastInsertId() method on the result to get the ID of our
-	// newly inserted record in the snippets table.
-	id, err := sqlResult.LastInsertId()
+	// The snippet and its other files are inserted in a transaction, so that a failure
+	// half-way through never leaves a snippet with only some of its files behind.
+	tx, err := sm.DB.BeginTx(ctx, nil)
 	if err != nil {
-		return 0, err
+		return Snippet{}, err
 	}
+	defer tx.Rollback()
+
+	for attempt := 1; ; attempt++ {
+		slug, err := newSlug()
+		if err != nil {
+			return Snippet{}, err
+		}
 
-	// The ID returned has the type int64, so we convert it to an int type before returning.
-	return int(id), nil
+		// Use the Exec() method on the transaction to execute the statement.
+		// The first parameter is the SQL statement,
+		// followed by the values for the placeholder parameters in the same order as the columns.
+		// This method returns a sql.Result type, which contains some
+		// basic information about what happened when the statement was executed.
+		sqlResult, err := tx.ExecContext(ctx, queryStmt, title, files[0].Name, files[0].Language, storedContent, dataKey, keyID, visibility, slug, ownerID, snippet.HashedPassword, encrypted, forkedFromID, expires_at)
+		if err != nil {
+			// If the slug is already taken, MySQL rejects the insert with a duplicate entry error (1062)
+			// on the idx_snippets_slug index. In that case we simply try again with a new slug.
+			var mySQLError *mysql.MySQLError
+			if errors.As(err, &mySQLError) && mySQLError.Number == 1062 &&
+				strings.Contains(mySQLError.Message, "idx_snippets_slug") && attempt < maxSlugAttempts {
+				continue
+			}
+
+			return Snippet{}, err
+		}
+
+		// Use the LastInsertId() method on the result to get the ID of our
+		// newly inserted record in the snippets table.
+		id, err := sqlResult.LastInsertId()
+		if err != nil {
+			return Snippet{}, err
+		}
+
+		// The ID returned has the type int64, so we convert it to an int type before returning.
+		snippet.ID = int(id)
+		snippet.Slug = slug
+
+		err = sm.insertFiles(ctx, tx, snippet.ID, files)
+		if err != nil {
+			return Snippet{}, err
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return Snippet{}, err
+		}
+
+		return snippet, nil
+	}
+}
+
+// Delete removes a snippet. Its files, attachment rows, comments, stars and view counts go with it,
+// as their foreign keys cascade, but the blobs of its attachments are left to the caller.
+func (sm *SnippetModel) Delete(ctx context.Context, id int) (err error) {
+	ctx, span := startSpan(ctx, "SnippetModel.Delete")
+	defer endSpan(span, &err)
+
+	_, err = sm.DB.ExecContext(ctx, `DELETE FROM snippets WHERE id = ?`, id)
+	return err
 }
 
-func (sm *SnippetModel) Get(id int) (Snippet, error) {
-	queryStmt := `SELECT id, title, content, created_at, expires_at FROM snippets
-	WHERE expires_at > UTC_TIMESTAMP() and id = ?`
+// The columns every snippet query selects, in the order that scanSnippet() expects them.
+// The columns are qualified with the table name, so that they can also be selected from joins with other tables.
+// The number of stars is counted with a correlated subquery.
+const snippetColumns = `snippets.id, snippets.slug, snippets.title, snippets.file_name, snippets.language,
+	snippets.content, snippets.data_key, snippets.key_id, snippets.visibility, snippets.owner_id,
+	snippets.hashed_password, snippets.encrypted, snippets.forked_from,
+	(SELECT COUNT(*) FROM stars WHERE stars.snippet_id = snippets.id), snippets.created_at, snippets.expires_at`
+
+// scanner is satisfied by both *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// scanSnippet copies the snippetColumns of the current row into a Snippet struct,
+// decrypting the content if it is encrypted at rest. Only the first file is filled in.
+func (sm *SnippetModel) scanSnippet(row scanner) (Snippet, error) {
+	var (
+		snippet    Snippet
+		file       File
+		dataKey    []byte
+		keyID      sql.NullString
+		forkedFrom sql.NullInt64
+	)
+
+	// Notice that the arguments to Scan are *pointers* to the place you want to copy the data into,
+	// and the number of arguments must be exactly the same as the number of columns returned by your statement.
+	err := row.Scan(&snippet.ID, &snippet.Slug, &snippet.Title, &file.Name, &file.Language, &snippet.Content, &dataKey, &keyID, &snippet.Visibility,
+		&snippet.OwnerID, &snippet.HashedPassword, &snippet.Encrypted, &forkedFrom, &snippet.Stars, &snippet.CreatedAt, &snippet.ExpiresAt)
+	if err != nil {
+		return Snippet{}, err
+	}
+
+	snippet.ForkedFrom = int(forkedFrom.Int64)
+
+	snippet.Content, err = sm.openContent(snippet.Content, dataKey, keyID.String)
+	if err != nil {
+		return Snippet{}, err
+	}
+
+	// Snippets from before multi-file support have no file name.
+	if file.Name == "" {
+		file.Name = defaultFileName
+	}
+	file.Content = snippet.Content
+	snippet.Files = []File{file}
+
+	return snippet, nil
+}
+
+// sealContent encrypts content for storage. Without a keyring the content is stored as it is,
+// with a NULL data key and key ID.
+func (sm *SnippetModel) sealContent(content string) (string, []byte, sql.NullString, error) {
+	if sm.Keys == nil {
+		return content, nil, sql.NullString{}, nil
+	}
+
+	ciphertext, dataKey, keyID, err := sm.Keys.Seal([]byte(content))
+	if err != nil {
+		return "", nil, sql.NullString{}, err
+	}
+
+	// The content column is TEXT, so the binary ciphertext is stored base64-encoded.
+	return base64.StdEncoding.EncodeToString(ciphertext), dataKey, sql.NullString{String: keyID, Valid: true}, nil
+}
+
+// openContent reverses sealContent.
+func (sm *SnippetModel) openContent(stored string, dataKey []byte, keyID string) (string, error) {
+	if dataKey == nil {
+		return stored, nil
+	}
+
+	if sm.Keys == nil {
+		return "", envelope.ErrNoKey
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(stored)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext, err := sm.Keys.Open(ciphertext, dataKey, keyID)
+	if err != nil {
+		return "", err
+	}
+
+	return string(plaintext), nil
+}
+
+// Get returns the public snippet with the given ID. It only exists to support the old numeric URLs;
+// non-public snippets are deliberately not reachable by their sequential ID.
+func (sm *SnippetModel) Get(ctx context.Context, id int) (_ Snippet, err error) {
+	ctx, span := startSpan(ctx, "SnippetModel.Get")
+	defer endSpan(span, &err)
+
+	queryStmt := `SELECT ` + snippetColumns + ` FROM snippets
+	WHERE expires_at > UTC_TIMESTAMP() AND visibility = 'public' AND id = ?`
+
 	// Use the QueryRow() method on the connection pool to execute our SQL statement,
 	// passing in the untrusted id variable as the value for the placeholder param.
 	// This returns a pointer to a sql.Row object which holds the result from the db.
-	row := sm.DB.QueryRow(queryStmt, id)
+	return sm.getOne(ctx, queryStmt, id)
+}
 
-	// Init a new zeroed Snippet struct.
-	var snippet Snippet
+// GetBySlug returns the snippet with the given slug, whatever its visibility.
+// It's up to the caller to check that the current user is allowed to see a private snippet.
+func (sm *SnippetModel) GetBySlug(ctx context.Context, slug string) (_ Snippet, err error) {
+	ctx, span := startSpan(ctx, "SnippetModel.GetBySlug")
+	defer endSpan(span, &err)
 
-	// Use row.Scan() to copy the values from each field in sql.Row to the corresponding field in the Snippet struct.
-	// Notice that the arguments to row.Scan are *pointers* to the place you want to copy the data into,
-	// and the number of arguments must be exactly the same as the number of columns returned by your statement.
-	err := row.Scan(&snippet.ID, &snippet.Title, &snippet.Content, &snippet.CreatedAt, &snippet.ExpiresAt)
+	queryStmt := `SELECT ` + snippetColumns + ` FROM snippets
+	WHERE expires_at > UTC_TIMESTAMP() AND slug = ?`
+
+	return sm.getOne(ctx, queryStmt, slug)
+}
+
+// GetOriginal returns the snippet that the given snippet was forked from, whatever its visibility.
+// It's up to the caller to check that the current user is allowed to see it.
+func (sm *SnippetModel) GetOriginal(ctx context.Context, snippet Snippet) (_ Snippet, err error) {
+	ctx, span := startSpan(ctx, "SnippetModel.GetOriginal")
+	defer endSpan(span, &err)
+
+	if snippet.ForkedFrom == 0 {
+		return Snippet{}, ErrNoRecord
+	}
+
+	queryStmt := `SELECT ` + snippetColumns + ` FROM snippets
+	WHERE expires_at > UTC_TIMESTAMP() AND id = ?`
+
+	return sm.getOne(ctx, queryStmt, snippet.ForkedFrom)
+}
+
+// Forks returns the public forks of a snippet, newest first. Only the first file of each fork is loaded.
+func (sm *SnippetModel) Forks(ctx context.Context, snippetID int) (_ []Snippet, err error) {
+	ctx, span := startSpan(ctx, "SnippetModel.Forks")
+	defer endSpan(span, &err)
+
+	queryStmt := `SELECT ` + snippetColumns + ` FROM snippets
+	WHERE expires_at > UTC_TIMESTAMP() AND visibility = 'public' AND forked_from = ? ORDER BY id DESC LIMIT 50`
+
+	return sm.getMany(ctx, queryStmt, snippetID)
+}
+
+// StarredBy returns one page of the snippets an owner has starred, most recently starred first,
+// along with the total number of them. Private snippets are only included if they belong to the owner.
+// Only the first file of each snippet is loaded.
+func (sm *SnippetModel) StarredBy(ctx context.Context, ownerID string, page, perPage int) (_ []Snippet, _ int, err error) {
+	ctx, span := startSpan(ctx, "SnippetModel.StarredBy")
+	defer endSpan(span, &err)
+
+	where := `FROM snippets JOIN stars ON stars.snippet_id = snippets.id
+	WHERE stars.owner_id = ? AND snippets.expires_at > UTC_TIMESTAMP()
+	AND (snippets.visibility <> 'private' OR snippets.owner_id = ?)`
+
+	var total int
+
+	err = sm.DB.QueryRowContext(ctx, `SELECT COUNT(*) `+where, ownerID, ownerID).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	queryStmt := `SELECT ` + snippetColumns + ` ` + where + ` ORDER BY stars.created_at DESC, snippets.id DESC LIMIT ? OFFSET ?`
+
+	snippets, err := sm.getMany(ctx, queryStmt, ownerID, ownerID, perPage, (page-1)*perPage)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return snippets, total, nil
+}
+
+// getOne runs a query for a single snippet, and loads all of its files.
+func (sm *SnippetModel) getOne(ctx context.Context, queryStmt string, args ...any) (Snippet, error) {
+	snippet, err := sm.scanSnippet(sm.DB.QueryRowContext(ctx, queryStmt, args...))
+	if err == nil {
+		err = sm.loadFiles(ctx, &snippet)
+	}
 
 	// If the query returns no rows, then row.Scan() will return a sql.ErrNoRows error.
 	// We use the errors.Is() function check for that error specifically,
@@ -81,19 +431,66 @@ func (sm *SnippetModel) Get(id int) (Snippet, error) {
 	return snippet, nil
 }
 
-// This will return the 10 most recently created snippets.
-func (sm *SnippetModel) Latest() ([]Snippet, error) {
-	queryStmp := `SELECT id, title, content, created_at, expires_at FROM snippets
-	WHERE expires_at > UTC_TIMESTAMP() ORDER BY id DESC LIMIT 10`
+// This will return the 10 most recently created public snippets.
+// Unlisted and private snippets never show up here.
+// Only the first file of each snippet is loaded.
+func (sm *SnippetModel) Latest(ctx context.Context) (_ []Snippet, err error) {
+	ctx, span := startSpan(ctx, "SnippetModel.Latest")
+	defer endSpan(span, &err)
+
+	queryStmp := `SELECT ` + snippetColumns + ` FROM snippets
+	WHERE expires_at > UTC_TIMESTAMP() AND visibility = 'public' ORDER BY id DESC LIMIT 10`
+
+	return sm.getMany(ctx, queryStmp)
+}
+
+// LatestByLanguage returns the 10 most recently created public snippets
+// whose first file is written in the given language.
+func (sm *SnippetModel) LatestByLanguage(ctx context.Context, language string) (_ []Snippet, err error) {
+	ctx, span := startSpan(ctx, "SnippetModel.LatestByLanguage")
+	defer endSpan(span, &err)
+
+	queryStmt := `SELECT ` + snippetColumns + ` FROM snippets
+	WHERE expires_at > UTC_TIMESTAMP() AND visibility = 'public' AND language = ? ORDER BY id DESC LIMIT 10`
+
+	return sm.getMany(ctx, queryStmt, language)
+}
+
+// LatestByOwner returns the 10 most recently created public snippets of an owner.
+func (sm *SnippetModel) LatestByOwner(ctx context.Context, ownerID string) (_ []Snippet, err error) {
+	ctx, span := startSpan(ctx, "SnippetModel.LatestByOwner")
+	defer endSpan(span, &err)
+
+	queryStmt := `SELECT ` + snippetColumns + ` FROM snippets
+	WHERE expires_at > UTC_TIMESTAMP() AND visibility = 'public' AND owner_id = ? ORDER BY id DESC LIMIT 10`
+
+	return sm.getMany(ctx, queryStmt, ownerID)
+}
+
+// InCollection returns the snippets of a collection in their order, leaving out any that have expired.
+// Only the first file of each snippet is loaded.
+func (sm *SnippetModel) InCollection(ctx context.Context, collectionID int) (_ []Snippet, err error) {
+	ctx, span := startSpan(ctx, "SnippetModel.InCollection")
+	defer endSpan(span, &err)
+
+	queryStmt := `SELECT ` + snippetColumns + ` FROM snippets
+	JOIN collection_snippets ON collection_snippets.snippet_id = snippets.id
+	WHERE collection_snippets.collection_id = ? AND snippets.expires_at > UTC_TIMESTAMP()
+	ORDER BY collection_snippets.position`
+
+	return sm.getMany(ctx, queryStmt, collectionID)
+}
 
+// getMany runs a query for a list of snippets. Only the first file of each snippet is loaded.
+func (sm *SnippetModel) getMany(ctx context.Context, queryStmt string, args ...any) ([]Snippet, error) {
 	// Use the Query() method on the connection pool to execute our SQL statement.
 	// This returns a sql.Rows resultset containing the result of our query.
-	rows, err := sm.DB.Query(queryStmp)
+	rows, err := sm.DB.QueryContext(ctx, queryStmt, args...)
 	if err != nil {
 		return nil, err
 	}
 
-	// We defer rows.Close() to ensure the sql.Rows resultset is always properly closed before the Latest() method returns.
+	// We defer rows.Close() to ensure the sql.Rows resultset is always properly closed before getMany() returns.
 	// This defer statement should come *after* you check for an error from the Query() method.
 	// Otherwise, if Query() returns an error, you'll get a panic trying to close a nil resultset.
 	defer rows.Close()
@@ -105,12 +502,8 @@ func (sm *SnippetModel) Latest() ([]Snippet, error) {
 	// This prepares the first (and then each subsequent) row to be acted on by the rows.Scan() method.
 	// If iteration over all the rows completes then the resultset automatically closes itself and frees-up the underlying db connection.
 	for rows.Next() {
-		// Create a pointer to a new zeroed Snippet struct.
-		var snippet Snippet
-		// Use rows.Scan() to copy the values from each field in the row to the new Snippet object that we created.
-		// Again, the arguments to row.Scan() must be pointers to the place you want to copy the data into,
-		// and the number of arguments must be exactly the same as the number of columns returned by your statement.
-		err := rows.Scan(&snippet.ID, &snippet.Title, &snippet.Content, &snippet.CreatedAt, &snippet.ExpiresAt)
+		// Use sm.scanSnippet() to copy the values from each field in the row to a new Snippet object.
+		snippet, err := sm.scanSnippet(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -128,3 +521,113 @@ func (sm *SnippetModel) Latest() ([]Snippet, error) {
 	// If everything went OK then return the Snippets slice.
 	return snippets, nil
 }
+
+// The number of rows RewrapKeys() reads and updates at a time.
+const rewrapBatchSize = 100
+
+// RewrapKeys makes sure that every snippet is encrypted with a data key wrapped by the primary master key.
+// Data keys wrapped by an older master key are re-wrapped (the content itself is left untouched),
+// and snippets that are still stored in plain text are encrypted. It returns the number of updated snippets.
+func (sm *SnippetModel) RewrapKeys() (int, error) {
+	if sm.Keys == nil {
+		return 0, envelope.ErrNoKey
+	}
+
+	updated := 0
+
+	// Both tables that hold file content have the same id, content, data_key and key_id columns.
+	for _, table := range []string{"snippets", "snippet_files"} {
+		n, err := sm.rewrapTable(table)
+		updated += n
+		if err != nil {
+			return updated, err
+		}
+	}
+
+	return updated, nil
+}
+
+func (sm *SnippetModel) rewrapTable(table string) (int, error) {
+	selectStmt := `SELECT id, content, data_key, key_id FROM ` + table + `
+	WHERE id > ? AND (key_id IS NULL OR key_id <> ?) ORDER BY id LIMIT ?`
+	updateStmt := `UPDATE ` + table + ` SET content = ?, data_key = ?, key_id = ? WHERE id = ?`
+
+	type row struct {
+		id      int
+		content string
+		dataKey []byte
+		keyID   sql.NullString
+	}
+
+	updated, lastID := 0, 0
+
+	for {
+		// Read a whole batch before updating it, so that we never hold a result set open while writing.
+		rows, err := sm.DB.Query(selectStmt, lastID, sm.Keys.PrimaryID(), rewrapBatchSize)
+		if err != nil {
+			return updated, err
+		}
+
+		var batch []row
+		for rows.Next() {
+			var r row
+			err := rows.Scan(&r.id, &r.content, &r.dataKey, &r.keyID)
+			if err != nil {
+				rows.Close()
+				return updated, err
+			}
+			batch = append(batch, r)
+		}
+		rows.Close()
+
+		if err := rows.Err(); err != nil {
+			return updated, err
+		}
+
+		if len(batch) == 0 {
+			return updated, nil
+		}
+
+		for _, r := range batch {
+			var (
+				content = r.content
+				dataKey []byte
+				keyID   sql.NullString
+			)
+
+			if r.dataKey == nil {
+				content, dataKey, keyID, err = sm.sealContent(r.content)
+			} else {
+				keyID.Valid = true
+				dataKey, keyID.String, err = sm.Keys.Rewrap(r.dataKey, r.keyID.String)
+			}
+			if err != nil {
+				return updated, fmt.Errorf("%s %d: %w", table, r.id, err)
+			}
+
+			_, err = sm.DB.Exec(updateStmt, content, dataKey, keyID, r.id)
+			if err != nil {
+				return updated, err
+			}
+
+			updated++
+			lastID = r.id
+		}
+	}
+}
+
+// SlugLength is the number of characters in every snippet slug.
+const SlugLength = 12
+
+// newSlug returns a random, URL-safe string that is practically impossible to guess.
+// 9 random bytes encode to exactly SlugLength base64 characters, without any padding.
+func newSlug() (string, error) {
+	b := make([]byte, SlugLength/4*3)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"database/sql"
+)
+
+// Define a StarModel type which wraps a sql.DB connection pool. Stars are kept in a join table between
+// owners and snippets, whose primary key makes starring the same snippet twice impossible:
+//
+//	CREATE TABLE stars (
+//	    owner_id CHAR(64) NOT NULL,
+//	    snippet_id INTEGER NOT NULL,
+//	    created_at DATETIME NOT NULL,
+//	    PRIMARY KEY (owner_id, snippet_id),
+//	    CONSTRAINT fk_stars_snippet FOREIGN KEY (snippet_id) REFERENCES snippets(id) ON DELETE CASCADE
+//	);
+//	CREATE INDEX idx_stars_snippet ON stars(snippet_id);
+type StarModel struct {
+	DB *sql.DB
+}
+
+// Star stars a snippet for an owner. Starring a snippet that is already starred does nothing.
+func (stm *StarModel) Star(ownerID string, snippetID int) error {
+	queryStmt := `INSERT IGNORE INTO stars (owner_id, snippet_id, created_at) VALUES(?, ?, UTC_TIMESTAMP())`
+
+	_, err := stm.DB.Exec(queryStmt, ownerID, snippetID)
+	return err
+}
+
+// Unstar removes an owner's star from a snippet. Unstarring a snippet that isn't starred does nothing.
+func (stm *StarModel) Unstar(ownerID string, snippetID int) error {
+	queryStmt := `DELETE FROM stars WHERE owner_id = ? AND snippet_id = ?`
+
+	_, err := stm.DB.Exec(queryStmt, ownerID, snippetID)
+	return err
+}
+
+// Exists reports whether an owner has starred a snippet.
+func (stm *StarModel) Exists(ownerID string, snippetID int) (bool, error) {
+	var exists bool
+
+	queryStmt := `SELECT EXISTS(SELECT true FROM stars WHERE owner_id = ? AND snippet_id = ?)`
+
+	err := stm.DB.QueryRow(queryStmt, ownerID, snippetID).Scan(&exists)
+	return exists, err
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"context"
+	"errors"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates a span for every query method of the models. It comes from the global tracer provider,
+// which cmd/web sets up at startup. Until then (and in cmd/rotatekeys) the spans go nowhere.
+var tracer = otel.Tracer("snippetbox.t10i.net/internal/models")
+
+// startSpan starts the span of a database query, as a child of the span in ctx.
+func startSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("db.system", "mysql")))
+}
+
+// endSpan ends the span of a query, marking it as failed if *err is set. It's meant to be deferred
+// with a pointer to the named error result of the method. ErrNoRecord isn't a failure of the query,
+// only an empty result.
+func endSpan(span trace.Span, err *error) {
+	if *err != nil && !errors.Is(*err, ErrNoRecord) {
+		span.RecordError(*err)
+		span.SetStatus(codes.Error, (*err).Error())
+	}
+	span.End()
+}
@@ -0,0 +1,65 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// How often the MemoryStore forgets the buckets which have filled up again.
+const memorySweepInterval = time.Minute
+
+// MemoryStore keeps the buckets in memory. They are lost when the application restarts,
+// and every instance of the application has buckets of its own.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*memoryBucket
+	lastSweep time.Time
+}
+
+type memoryBucket struct {
+	bucket
+	fullAt time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*memoryBucket), lastSweep: time.Now()}
+}
+
+func (s *MemoryStore) TakeAll(ctx context.Context, keys []string, limit Limit) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	// Drop the full buckets while we hold the lock anyway, so that the map doesn't keep growing
+	// with every client that ever made a request.
+	if now.Sub(s.lastSweep) >= memorySweepInterval {
+		for k, b := range s.buckets {
+			if !now.Before(b.fullAt) {
+				delete(s.buckets, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	buckets := make([]*memoryBucket, len(keys))
+	states := make([]*bucket, len(keys))
+
+	for i, key := range keys {
+		b, ok := s.buckets[key]
+		if !ok {
+			b = &memoryBucket{bucket: bucket{tokens: float64(limit.Burst), updated: now}}
+			s.buckets[key] = b
+		}
+		buckets[i], states[i] = b, &b.bucket
+	}
+
+	allowed, retryAfter := takeAll(states, limit, now)
+
+	for _, b := range buckets {
+		b.fullAt = b.bucket.fullAt(limit)
+	}
+
+	return allowed, retryAfter, nil
+}
@@ -0,0 +1,105 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+)
+
+// How often a MySQLStore deletes the buckets which have filled up again.
+const mysqlSweepInterval = 10 * time.Minute
+
+// MySQLStore keeps the buckets in a MySQL table, so that all the instances of the application share them:
+//
+//	CREATE TABLE rate_limits (
+//	    bucket VARCHAR(255) NOT NULL PRIMARY KEY,
+//	    tokens DOUBLE NOT NULL,
+//	    updated_at DATETIME(6) NOT NULL,
+//	    full_at DATETIME(6) NOT NULL
+//	);
+//	CREATE INDEX idx_rate_limits_full_at ON rate_limits(full_at);
+//
+// The instances use their own clocks, so they should be kept in sync with NTP.
+// The DSN must have parseTime=true.
+type MySQLStore struct {
+	DB *sql.DB
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+func (s *MySQLStore) TakeAll(ctx context.Context, keys []string, limit Limit) (bool, time.Duration, error) {
+	now := time.Now().UTC()
+
+	s.sweep(ctx, now)
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, 0, err
+	}
+	defer tx.Rollback()
+
+	// Lock the rows, so that the instances take tokens from the buckets one after the other. They are
+	// locked in the order of their keys, so that two requests for the same buckets can't deadlock.
+	keys = slices.Clone(keys)
+	slices.Sort(keys)
+
+	buckets := make([]*bucket, len(keys))
+
+	for i, key := range keys {
+		b := &bucket{tokens: float64(limit.Burst), updated: now}
+
+		err = tx.QueryRowContext(ctx, `SELECT tokens, updated_at FROM rate_limits WHERE bucket = ? FOR UPDATE`, key).
+			Scan(&b.tokens, &b.updated)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return false, 0, err
+		}
+
+		buckets[i] = b
+	}
+
+	// If the request is refused, nothing is taken, and there's nothing to write back: refilling the buckets
+	// again later gives the same result.
+	allowed, retryAfter := takeAll(buckets, limit, now)
+	if !allowed {
+		return false, retryAfter, nil
+	}
+
+	for i, key := range keys {
+		b := buckets[i]
+
+		// Two instances can both find no row for a new bucket. The second insert then simply overwrites
+		// the first one, which at worst lets one extra request through.
+		_, err = tx.ExecContext(ctx, `INSERT INTO rate_limits (bucket, tokens, updated_at, full_at) VALUES (?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE tokens = VALUES(tokens), updated_at = VALUES(updated_at), full_at = VALUES(full_at)`,
+			key, b.tokens, b.updated, b.fullAt(limit))
+		if err != nil {
+			return false, 0, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return false, 0, err
+	}
+
+	return true, 0, nil
+}
+
+// sweep deletes the full buckets every now and then. Errors are ignored, since the rows are only
+// deleted to keep the table small and the next sweep tries again.
+func (s *MySQLStore) sweep(ctx context.Context, now time.Time) {
+	s.mu.Lock()
+	due := now.Sub(s.lastSweep) >= mysqlSweepInterval
+	if due {
+		s.lastSweep = now
+	}
+	s.mu.Unlock()
+
+	if due {
+		s.DB.ExecContext(ctx, `DELETE FROM rate_limits WHERE full_at <= ?`, now)
+	}
+}
@@ -0,0 +1,120 @@
+// Package ratelimit implements token bucket rate limits. The buckets are kept in a Store,
+// either in the memory of one instance or in MySQL, to be shared by all the instances of the application.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit describes a token bucket: it holds up to Burst tokens, and refills at Rate tokens per second.
+// Every request takes one token, so Burst requests can be made at once, and Rate per second after that.
+// The zero Limit allows everything.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// ParseLimit parses a limit written as "<count>/<period>", like "60/1m": a bucket of count tokens which
+// refills completely over the period. The period is a time.Duration. "0" or "off" disable the limit.
+func ParseLimit(s string) (Limit, error) {
+	if s == "0" || s == "off" {
+		return Limit{}, nil
+	}
+
+	count, period, ok := strings.Cut(s, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("ratelimit: invalid limit %q (use <count>/<period>, like 60/1m)", s)
+	}
+
+	n, err := strconv.Atoi(count)
+	if err != nil || n <= 0 {
+		return Limit{}, fmt.Errorf("ratelimit: invalid count in limit %q", s)
+	}
+
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return Limit{}, fmt.Errorf("ratelimit: invalid period in limit %q", s)
+	}
+
+	return Limit{Rate: float64(n) / d.Seconds(), Burst: n}, nil
+}
+
+// String formats the limit in the form accepted by ParseLimit().
+func (l Limit) String() string {
+	if l.Unlimited() {
+		return "off"
+	}
+	period := time.Duration(float64(l.Burst) / l.Rate * float64(time.Second)).Round(time.Millisecond)
+	return fmt.Sprintf("%d/%s", l.Burst, period)
+}
+
+// Unlimited reports whether the limit allows everything.
+func (l Limit) Unlimited() bool {
+	return l.Rate <= 0 || l.Burst <= 0
+}
+
+// Store holds the token buckets, by key.
+type Store interface {
+	// TakeAll takes a token from each of the buckets with the given keys, creating full buckets where there
+	// are none. A request is often counted against more than one bucket, and it's all or nothing: if any of
+	// them is empty, no token is taken from the others either, and TakeAll returns false and how long it
+	// takes until every bucket has a token again.
+	TakeAll(ctx context.Context, keys []string, limit Limit) (bool, time.Duration, error)
+}
+
+// bucket is the state of one token bucket: the number of tokens it held at the last update.
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// refill adds the tokens for the time passed since the last update of the bucket.
+func (b *bucket) refill(limit Limit, now time.Time) {
+	elapsed := now.Sub(b.updated).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(float64(limit.Burst), b.tokens+elapsed*limit.Rate)
+	}
+	b.updated = now
+}
+
+// wait returns how long it takes until the bucket holds a token, which is 0 if it holds one already.
+func (b *bucket) wait(limit Limit) time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+
+	wait := (1 - b.tokens) / limit.Rate
+	return time.Duration(math.Ceil(wait * float64(time.Second)))
+}
+
+// takeAll refills the buckets, and then takes a token from each of them if every one of them has one.
+func takeAll(buckets []*bucket, limit Limit, now time.Time) (bool, time.Duration) {
+	var retryAfter time.Duration
+
+	for _, b := range buckets {
+		b.refill(limit, now)
+		retryAfter = max(retryAfter, b.wait(limit))
+	}
+
+	if retryAfter > 0 {
+		return false, retryAfter
+	}
+
+	for _, b := range buckets {
+		b.tokens--
+	}
+
+	return true, 0
+}
+
+// fullAt returns when the bucket will be full again. From then on it's no different from a new bucket,
+// so it can be forgotten.
+func (b *bucket) fullAt(limit Limit) time.Time {
+	missing := float64(limit.Burst) - b.tokens
+	return b.updated.Add(time.Duration(missing / limit.Rate * float64(time.Second)))
+}
@@ -0,0 +1,198 @@
+// Package settings loads the settings of the Snippetbox commands. Every setting is defined as a flag on a
+// flag.FlagSet, and can also be given in a configuration file (with underscores instead of dashes, like
+// log_level) or in a SNIPPETBOX_* environment variable (like SNIPPETBOX_LOG_LEVEL). The layers are applied
+// in this order, each one overriding the previous: the defaults, the file, the environment and the command line.
+package settings
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// The prefix of the environment variables which set configuration values, like SNIPPETBOX_ADDR for -addr.
+const EnvPrefix = "SNIPPETBOX_"
+
+// The places a configuration value can come from, in the order they are applied.
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+// Sources records where the value of each setting came from.
+type Sources map[string]string
+
+// Get returns where the value of a setting came from.
+func (s Sources) Get(name string) string {
+	if source, ok := s[name]; ok {
+		return source
+	}
+	return SourceDefault
+}
+
+// Load builds the settings defined on fs from the defaults, the configuration file, the environment and
+// the command-line arguments. Values which were given on the command line are never overridden, so the
+// command line is parsed first, and then the file and the environment only fill in the other settings.
+//
+// fs must define a "config" flag for the configuration file. It can also be set in the SNIPPETBOX_CONFIG
+// environment variable, but not in the file itself. Settings in the file which fs doesn't define are an
+// error, so that a typo doesn't go unnoticed, unless partial is true: then they are skipped, so that a
+// command which needs only a few of the settings can share the configuration file of the web application.
+func Load(fs *flag.FlagSet, args []string, partial bool) (Sources, error) {
+	sources := make(Sources)
+
+	// Importantly, we parse the command-line flags before using any of the values.
+	// If any errors are encountered during parsing the application will be terminated.
+	fs.Parse(args)
+
+	fs.Visit(func(f *flag.Flag) {
+		sources[f.Name] = SourceFlag
+	})
+
+	path := fs.Lookup("config").Value.String()
+	if path == "" {
+		if value, ok := os.LookupEnv(EnvPrefix + "CONFIG"); ok {
+			path = value
+			fs.Set("config", path)
+			sources["config"] = SourceEnv
+		}
+	}
+
+	if path != "" {
+		err := loadFile(fs, sources, path, partial)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := loadEnv(fs, sources)
+	if err != nil {
+		return nil, err
+	}
+
+	return sources, nil
+}
+
+// loadFile applies the settings from a configuration file. The format is chosen by the extension of the
+// file. The settings must be at the top level of the file.
+func loadFile(fs *flag.FlagSet, sources Sources, path string, partial bool) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+
+	values := make(map[string]any)
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".toml":
+		err = toml.Unmarshal(b, &values)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &values)
+	case ".json":
+		// Decode numbers as json.Number, so that a large integer isn't turned into a float like 1e+06.
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		err = dec.Decode(&values)
+	default:
+		return fmt.Errorf("config: %s: unsupported file type %q (use .toml, .yaml or .json)", path, ext)
+	}
+	if err != nil {
+		return fmt.Errorf("config: %s: %w", path, err)
+	}
+
+	for key, value := range values {
+		name := strings.ReplaceAll(key, "_", "-")
+
+		if fs.Lookup(name) == nil || name == "config" {
+			if partial && name != "config" {
+				continue
+			}
+			return fmt.Errorf("config: %s: unknown setting %q", path, key)
+		}
+
+		switch value.(type) {
+		case string, bool, int, int64, uint64, float64, json.Number:
+		default:
+			return fmt.Errorf("config: %s: %s must be a string, number or boolean", path, key)
+		}
+
+		if sources[name] == SourceFlag {
+			continue
+		}
+
+		err := fs.Set(name, fmt.Sprint(value))
+		if err != nil {
+			return fmt.Errorf("config: %s: %s: %w", path, key, err)
+		}
+
+		sources[name] = SourceFile
+	}
+
+	return nil
+}
+
+// loadEnv applies the settings from SNIPPETBOX_* environment variables.
+func loadEnv(fs *flag.FlagSet, sources Sources) error {
+	var err error
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || f.Name == "config" || sources[f.Name] == SourceFlag {
+			return
+		}
+
+		key := EnvPrefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+
+		value, ok := os.LookupEnv(key)
+		if !ok {
+			return
+		}
+
+		err = fs.Set(f.Name, value)
+		if err != nil {
+			err = fmt.Errorf("config: %s: %w", key, err)
+			return
+		}
+
+		sources[f.Name] = SourceEnv
+	})
+
+	return err
+}
+
+// LoadFromFile reads the value of the setting name from the file named by the setting name-file, if that
+// is set, like a mounted Docker or Kubernetes secret. Setting both would leave it unclear which one is used.
+func LoadFromFile(fs *flag.FlagSet, sources Sources, name string) error {
+	path := fs.Lookup(name + "-file").Value.String()
+	if path == "" {
+		return nil
+	}
+
+	key := strings.ReplaceAll(name, "-", "_")
+
+	if sources.Get(name) != SourceDefault {
+		return fmt.Errorf("config: only one of %s and %s_file can be set", key, key)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config: %s_file: %w", key, err)
+	}
+
+	err = fs.Set(name, strings.TrimSpace(string(b)))
+	if err != nil {
+		return fmt.Errorf("config: %s_file: %w", key, err)
+	}
+
+	sources[name] = sources.Get(name + "-file")
+
+	return nil
+}
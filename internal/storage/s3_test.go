@@ -0,0 +1,238 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeS3 is a minimal S3-compatible server which keeps the objects in memory. Like S3, it checks the
+// AWS Signature Version 4 of every request, calculated afresh from the same credentials, and refuses
+// chunked uploads.
+type fakeS3 struct {
+	accessKey string
+	secretKey string
+	region    string
+
+	mu      sync.Mutex
+	objects map[string][]byte
+	types   map[string]string
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := f.checkSignature(r); err != nil {
+		http.Error(w, "SignatureDoesNotMatch: "+err.Error(), http.StatusForbidden)
+		return
+	}
+
+	if len(r.TransferEncoding) > 0 {
+		http.Error(w, "NotImplemented: Transfer-Encoding", http.StatusNotImplemented)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodPut:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.objects[r.URL.Path] = body
+		f.types[r.URL.Path] = r.Header.Get("Content-Type")
+	case http.MethodGet:
+		body, ok := f.objects[r.URL.Path]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		w.Write(body)
+	case http.MethodDelete:
+		delete(f.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// checkSignature recalculates the signature of a request, following the AWS documentation
+// rather than the code under test, and compares it with the one in the Authorization header.
+func (f *fakeS3) checkSignature(r *http.Request) error {
+	auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ")
+	if !ok {
+		return errors.New("missing AWS4-HMAC-SHA256 authorization")
+	}
+
+	params := make(map[string]string)
+	for _, part := range strings.Split(auth, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		params[key] = value
+	}
+
+	amzDate := r.Header.Get("X-Amz-Date")
+	if len(amzDate) != len("20060102T150405Z") {
+		return errors.New("missing X-Amz-Date")
+	}
+
+	scope := amzDate[:8] + "/" + f.region + "/s3/aws4_request"
+	if params["Credential"] != f.accessKey+"/"+scope {
+		return fmt.Errorf("unexpected credential %q", params["Credential"])
+	}
+
+	signedHeaders := strings.Split(params["SignedHeaders"], ";")
+	if !slices.Contains(signedHeaders, "host") || !slices.Contains(signedHeaders, "x-amz-date") {
+		return errors.New("host and x-amz-date must be signed")
+	}
+
+	var canonicalHeaders strings.Builder
+	for _, name := range signedHeaders {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, strings.TrimSpace(value))
+	}
+
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		r.URL.RawQuery,
+		canonicalHeaders.String(),
+		params["SignedHeaders"],
+		r.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + f.secretKey)
+	for _, data := range []string{amzDate[:8], f.region, "s3", "aws4_request", stringToSign} {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(data))
+		key = mac.Sum(nil)
+	}
+
+	if !hmac.Equal([]byte(params["Signature"]), []byte(hex.EncodeToString(key))) {
+		return errors.New("signature mismatch")
+	}
+
+	return nil
+}
+
+func newTestS3Store(t *testing.T) (*S3Store, *fakeS3) {
+	t.Helper()
+
+	fake := &fakeS3{
+		accessKey: "key",
+		secretKey: "secret",
+		region:    "us-east-1",
+		objects:   make(map[string][]byte),
+		types:     make(map[string]string),
+	}
+
+	ts := httptest.NewServer(fake)
+	t.Cleanup(ts.Close)
+
+	store, err := NewS3Store(ts.URL, "snippets", fake.region, fake.accessKey, fake.secretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Client = ts.Client()
+
+	return store, fake
+}
+
+func TestS3Store(t *testing.T) {
+	store, fake := newTestS3Store(t)
+	ctx := context.Background()
+
+	const key = "attachments/ab/cd.txt"
+	content := "Hello, world!"
+
+	err := store.Put(ctx, key, strings.NewReader(content), int64(len(content)), "text/plain")
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	if got := fake.types["/snippets/"+key]; got != "text/plain" {
+		t.Errorf("Content-Type: got %q; want %q", got, "text/plain")
+	}
+
+	blob, err := store.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	body, err := io.ReadAll(blob)
+	blob.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(body) != content {
+		t.Errorf("Get: got %q; want %q", body, content)
+	}
+
+	err = store.Delete(ctx, key)
+	if err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	_, err = store.Get(ctx, key)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete: got %v; want ErrNotFound", err)
+	}
+}
+
+func TestS3StoreMissingKey(t *testing.T) {
+	store, _ := newTestS3Store(t)
+	ctx := context.Background()
+
+	_, err := store.Get(ctx, "attachments/missing")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get: got %v; want ErrNotFound", err)
+	}
+
+	// Deleting a missing blob is not an error.
+	err = store.Delete(ctx, "attachments/missing")
+	if err != nil {
+		t.Errorf("Delete: got %v; want nil", err)
+	}
+}
+
+func TestS3StoreEmptyBlob(t *testing.T) {
+	store, fake := newTestS3Store(t)
+	ctx := context.Background()
+
+	const key = "attachments/empty"
+
+	err := store.Put(ctx, key, strings.NewReader(""), 0, "text/plain")
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	if body, ok := fake.objects["/snippets/"+key]; !ok || len(body) != 0 {
+		t.Errorf("stored object: got %q, %t; want an empty object", body, ok)
+	}
+}
+
+func TestS3StoreWrongSecret(t *testing.T) {
+	store, _ := newTestS3Store(t)
+	store.SecretKey = "wrong"
+
+	_, err := store.Get(context.Background(), "attachments/missing")
+	if err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("Get: got %v; want a signature error", err)
+	}
+}
@@ -0,0 +1,23 @@
+// Package storage stores the binary content ("blobs") of snippet attachments,
+// either on the local filesystem or in an S3-compatible object store.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+var ErrNotFound = errors.New("storage: blob not found")
+
+// BlobStore is implemented by every place that attachments can be kept.
+// Keys are slash-separated paths made up of URL-safe characters.
+type BlobStore interface {
+	// Put stores size bytes read from r under key, replacing any existing blob.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get returns a reader for the blob stored under key, or ErrNotFound.
+	// The caller must close it.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the blob stored under key. Deleting a missing blob is not an error.
+	Delete(ctx context.Context, key string) error
+}
@@ -0,0 +1,137 @@
+package validator
+
+import (
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// A SecretFinding describes a piece of a value that looks like a credential.
+// Start and End are byte offsets into the scanned value.
+type SecretFinding struct {
+	Kind  string
+	Start int
+	End   int
+}
+
+// secretPatterns are the credential formats that ScanSecrets() recognises by their shape.
+var secretPatterns = []struct {
+	kind string
+	rx   *regexp.Regexp
+}{
+	{"AWS access key", regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"AWS secret key", regexp.MustCompile(`(?i)aws_?secret_?access_?key\s*[:=]\s*["']?[A-Za-z0-9/+]{40}\b`)},
+	{"Google API key", regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`)},
+	{"GitHub token", regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`)},
+	{"Slack token", regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}\b`)},
+	{"Stripe key", regexp.MustCompile(`\b[sr]k_live_[A-Za-z0-9]{24,}\b`)},
+	{"private key", regexp.MustCompile(`-----BEGIN (?:[A-Z]+ )?PRIVATE KEY( BLOCK)?-----[\s\S]*?(?:-----END (?:[A-Z]+ )?PRIVATE KEY( BLOCK)?-----|$)`)},
+	{"JSON Web Token", regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{8,}\.eyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{16,}`)},
+}
+
+// Long runs of base64-ish characters are checked for entropy, to catch secrets which have no recognisable prefix.
+var (
+	secretCandidateRX = regexp.MustCompile(`[A-Za-z0-9+/_=-]{32,}`)
+	hasLetterRX       = regexp.MustCompile(`[A-Za-z]`)
+	hasDigitRX        = regexp.MustCompile(`[0-9]`)
+)
+
+// The Shannon entropy, in bits per character, above which a candidate string is considered random.
+// Hex-encoded hashes (like git commit IDs) stay below it, random base64 keys don't.
+const secretEntropyThreshold = 4.5
+
+// ScanSecrets() returns the parts of value that look like credentials, ordered by their position.
+func ScanSecrets(value string) []SecretFinding {
+	var findings []SecretFinding
+
+	for _, p := range secretPatterns {
+		for _, loc := range p.rx.FindAllStringIndex(value, -1) {
+			findings = append(findings, SecretFinding{Kind: p.kind, Start: loc[0], End: loc[1]})
+		}
+	}
+
+	for _, loc := range secretCandidateRX.FindAllStringIndex(value, -1) {
+		candidate := value[loc[0]:loc[1]]
+		if overlaps(findings, loc[0], loc[1]) || !hasLetterRX.MatchString(candidate) || !hasDigitRX.MatchString(candidate) {
+			continue
+		}
+
+		if entropy(candidate) >= secretEntropyThreshold {
+			findings = append(findings, SecretFinding{Kind: "high-entropy string", Start: loc[0], End: loc[1]})
+		}
+	}
+
+	slices.SortFunc(findings, func(a, b SecretFinding) int {
+		return a.Start - b.Start
+	})
+
+	return findings
+}
+
+// RedactSecrets() returns value with every finding replaced by a [REDACTED ...] marker.
+// The findings must be ordered by their position, as returned by ScanSecrets().
+func RedactSecrets(value string, findings []SecretFinding) string {
+	var (
+		b    strings.Builder
+		last int
+	)
+
+	for _, f := range findings {
+		// Findings can overlap, for example a JWT inside a longer high-entropy string.
+		if f.End <= last {
+			continue
+		}
+		if f.Start > last {
+			b.WriteString(value[last:f.Start])
+		}
+		b.WriteString("[REDACTED " + f.Kind + "]")
+		last = f.End
+	}
+	b.WriteString(value[last:])
+
+	return b.String()
+}
+
+// SecretKinds() returns the distinct kinds of the findings, joined for use in an error message.
+func SecretKinds(findings []SecretFinding) string {
+	var kinds []string
+
+	for _, f := range findings {
+		if !slices.Contains(kinds, f.Kind) {
+			kinds = append(kinds, f.Kind)
+		}
+	}
+
+	return strings.Join(kinds, ", ")
+}
+
+func overlaps(findings []SecretFinding, start, end int) bool {
+	for _, f := range findings {
+		if start < f.End && f.Start < end {
+			return true
+		}
+	}
+
+	return false
+}
+
+// entropy returns the Shannon entropy of s in bits per character.
+func entropy(s string) float64 {
+	counts := make(map[rune]int)
+	for _, r := range s {
+		counts[r]++
+	}
+
+	var (
+		total = float64(len(s))
+		bits  float64
+	)
+
+	for _, n := range counts {
+		p := float64(n) / total
+		bits -= p * math.Log2(p)
+	}
+
+	return bits
+}
@@ -1,11 +1,19 @@
 package validator
 
 import (
+	"regexp"
 	"slices"
 	"strings"
 	"unicode/utf8"
 )
 
+// CiphertextRX matches the unpadded, URL-safe base64 encoding that the browser uses for encrypted snippets.
+var CiphertextRX = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// FileNameRX matches the names allowed for the files of a snippet. They can't contain slashes,
+// so that they are safe to use as paths inside a zip archive (as long as they aren't "." or "..").
+var FileNameRX = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
 // Define a new Validator struct which contains a map of validation error messages for our form fields.
 type Validator struct {
 	FieldErrors map[string]string
@@ -46,7 +54,22 @@ func MaxChars(value string, n int) bool {
 	return utf8.RuneCountInString(value) <= n
 }
 
+// MinChars() returns true if a value contains at least n characters.
+func MinChars(value string, n int) bool {
+	return utf8.RuneCountInString(value) >= n
+}
+
+// MaxBytes() returns true if a value is no more than n bytes long.
+func MaxBytes(value string, n int) bool {
+	return len(value) <= n
+}
+
 // PermittedValue() returns true if a value is in a list of specific permitted values.
 func PermittedValue[T comparable](value T, permittedValues ...T) bool {
 	return slices.Contains(permittedValues, value)
 }
+
+// Matches() returns true if a value matches a provided compiled regular expression pattern.
+func Matches(value string, rx *regexp.Regexp) bool {
+	return rx.MatchString(value)
+}
@@ -0,0 +1,117 @@
+// End-to-end encryption for snippets.
+//
+// When the "encrypted" box on the create form is ticked, the content is encrypted with AES-GCM
+// before the form is submitted. The key is put in the URL fragment, which browsers never send to
+// the server, and which they carry over the redirect to the new snippet's page. On that page the
+// key is read back from the fragment and the content is decrypted here, in the browser.
+//
+// Note that WebCrypto is only available in secure contexts (HTTPS or localhost).
+
+function toBase64URL(bytes) {
+	var binary = "";
+	for (var i = 0; i < bytes.length; i++) {
+		binary += String.fromCharCode(bytes[i]);
+	}
+	return btoa(binary).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+}
+
+function fromBase64URL(text) {
+	var base64 = text.replace(/-/g, "+").replace(/_/g, "/");
+	while (base64.length % 4 !== 0) {
+		base64 += "=";
+	}
+	var binary = atob(base64);
+	var bytes = new Uint8Array(binary.length);
+	for (var i = 0; i < binary.length; i++) {
+		bytes[i] = binary.charCodeAt(i);
+	}
+	return bytes;
+}
+
+// Encrypt the content of every file on the create form, if requested, and submit it.
+// All files are encrypted with the same key, but each with its own random IV.
+var createForm = document.querySelector("form[data-encryptable]");
+if (createForm) {
+	createForm.addEventListener("submit", function (event) {
+		var checkbox = createForm.querySelector("input[name='encrypted']");
+		if (!checkbox.checked || createForm.dataset.encrypted === "true") {
+			return;
+		}
+		event.preventDefault();
+
+		var textareas = createForm.querySelectorAll("fieldset.file textarea");
+		var key;
+
+		crypto.subtle.generateKey({name: "AES-GCM", length: 256}, true, ["encrypt"]).then(function (k) {
+			key = k;
+			var encryptions = [];
+			for (var i = 0; i < textareas.length; i++) {
+				encryptions.push(encryptInto(key, textareas[i]));
+			}
+			return Promise.all(encryptions);
+		}).then(function () {
+			return crypto.subtle.exportKey("raw", key);
+		}).then(function (rawKey) {
+			createForm.action = createForm.getAttribute("action").split("#")[0] + "#" + toBase64URL(new Uint8Array(rawKey));
+			createForm.dataset.encrypted = "true";
+			createForm.submit();
+		}).catch(function () {
+			alert("Your browser could not encrypt this snippet.");
+		});
+	});
+}
+
+// Keep the key of an encrypted snippet when it's unlocked with its password. The fragment isn't sent to the
+// server, so it's added to the action of the unlock form: browsers carry the fragment of the URL they posted to
+// over the redirect to the snippet's page, as long as the redirect doesn't have a fragment of its own.
+var unlockForm = document.querySelector("form[data-keep-fragment]");
+if (unlockForm) {
+	unlockForm.addEventListener("submit", function () {
+		unlockForm.action = unlockForm.getAttribute("action").split("#")[0] + window.location.hash;
+	});
+}
+
+// Replace the content of a textarea with its ciphertext, prefixed with the IV.
+function encryptInto(key, textarea) {
+	var iv = crypto.getRandomValues(new Uint8Array(12));
+	var plaintext = new TextEncoder().encode(textarea.value);
+
+	return crypto.subtle.encrypt({name: "AES-GCM", iv: iv}, key, plaintext).then(function (ciphertext) {
+		var payload = new Uint8Array(iv.length + ciphertext.byteLength);
+		payload.set(iv, 0);
+		payload.set(new Uint8Array(ciphertext), iv.length);
+		textarea.value = toBase64URL(payload);
+	});
+}
+
+// Decrypt the content of every file of an encrypted snippet with the key from the URL fragment.
+var encryptedFiles = document.querySelectorAll("code[data-ciphertext]");
+if (encryptedFiles.length > 0) {
+	var fragment = window.location.hash.substring(1);
+	if (fragment === "") {
+		for (var i = 0; i < encryptedFiles.length; i++) {
+			encryptedFiles[i].textContent = "This snippet is encrypted. The key is missing from the link.";
+		}
+	} else {
+		crypto.subtle.importKey("raw", fromBase64URL(fragment), {name: "AES-GCM"}, false, ["decrypt"]).then(function (key) {
+			for (var i = 0; i < encryptedFiles.length; i++) {
+				decryptInto(key, encryptedFiles[i]);
+			}
+		}).catch(function () {
+			for (var i = 0; i < encryptedFiles.length; i++) {
+				encryptedFiles[i].textContent = "This snippet could not be decrypted. The key in the link is wrong.";
+			}
+		});
+	}
+}
+
+function decryptInto(key, element) {
+	var payload = fromBase64URL(element.dataset.ciphertext);
+
+	crypto.subtle.decrypt({name: "AES-GCM", iv: payload.slice(0, 12)}, key, payload.slice(12)).then(function (plaintext) {
+		// Use textContent rather than innerHTML, so decrypted content can never be interpreted as markup.
+		element.textContent = new TextDecoder().decode(plaintext);
+	}).catch(function () {
+		element.textContent = "This snippet could not be decrypted. The key in the link is wrong.";
+	});
+}
@@ -0,0 +1,23 @@
+// Adds another file to the create form. The last file's fieldset is cloned, emptied,
+// and the index in the names of its inputs (like files[2].name) is incremented.
+var addFileButton = document.querySelector("button[data-add-file]");
+if (addFileButton) {
+	addFileButton.addEventListener("click", function () {
+		var fieldsets = document.querySelectorAll("fieldset.file");
+		var last = fieldsets[fieldsets.length - 1];
+		var copy = last.cloneNode(true);
+
+		var errors = copy.querySelectorAll("label.error");
+		for (var i = 0; i < errors.length; i++) {
+			errors[i].remove();
+		}
+
+		var inputs = copy.querySelectorAll("input, select, textarea");
+		for (var i = 0; i < inputs.length; i++) {
+			inputs[i].name = inputs[i].name.replace(/^files\[\d+\]/, "files[" + fieldsets.length + "]");
+			inputs[i].value = "";
+		}
+
+		last.parentNode.insertBefore(copy, addFileButton);
+	});
+}